@@ -1,50 +1,262 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 )
 
-type RepoConfig struct {
-	RepoPath string `json:"repo_path"`
-	Status   string `json:"status"`
+type Config struct {
+	// Schema version of the config file
+	Version        int
+	AutouploadDirs []string
+	BaseURL        string
+	AuthToken      string
+	GithubToken    string
+	// Providers tried in order when no GitHub token is configured
+	GithubTokenProviders []string
+	// External command that prints a GitHub token, e.g. "gh auth token"
+	GithubTokenCommand string `json:",omitempty"`
+	// Tokens for other remotes, keyed by remote type ("gitlab", "azure") or host
+	RemoteTokens map[string]string `json:",omitempty"`
+	// Remote types of self-hosted git hosts, e.g. "gitlab.example.com": "gitlab"
+	RemoteHosts map[string]string  `json:",omitempty"`
+	Profiles    map[string]Profile `json:",omitempty"`
+	// Git remote that identifies the repository
+	Remote string
+	// Branch to index and query instead of the checked out one
+	Branch string `json:",omitempty"`
+	// Use genius mode for queries
+	Genius bool
+	// Paths that query answers should focus on
+	Scope []string `json:",omitempty"`
+	// Attempts per API request including the first one; 1 disables retries
+	RetryMaxAttempts int
+	// Longest time to spend retrying a single API request
+	RetryMaxElapsed Duration
+	// Longest time to wait for a connection to the API
+	ConnectTimeout Duration
+	// Longest time for an API operation including retries; 0 for no limit
+	Timeout Duration
+	// Per-operation overrides of Timeout; 0 uses Timeout
+	IndexTimeout  Duration `json:",omitempty"`
+	InfoTimeout   Duration `json:",omitempty"`
+	QueryTimeout  Duration `json:",omitempty"`
+	SearchTimeout Duration `json:",omitempty"`
+	// Proxy for API requests; empty uses HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+	ProxyURL string `json:",omitempty"`
+	// PEM files of root CAs trusted for API requests besides the system ones
+	CABundles []string `json:",omitempty"`
+	// PEM client certificate and key presented for mutual TLS
+	ClientCert string `json:",omitempty"`
+	ClientKey  string `json:",omitempty"`
+	// Lowest TLS version accepted for API requests, e.g. "1.2"
+	MinTLSVersion string
+	ConfigFile    string `json:"-"`
+	// Per-repository config file applied by ForRepo, if any
+	RepoConfigFile string `json:"-"`
+	// Name of the active profile, if any
+	Profile string `json:"-"`
+
+	// Where each key's effective value came from
+	sources map[string]Source
+	// Keys as they were read from the config file
+	fileValues map[string]json.RawMessage
+	// Entries from the credentials file
+	credentials map[string]Credential
+	// Effective remote tokens from the file, credentials and environment
+	remoteTokens map[string]string
 }
 
-type Config struct {
-	AutouploadRepos []RepoConfig
-	AutouploadDirs  []string
-	BaseURL         string
-	AuthToken       string
-	GithubToken     string
-	ConfigFile      string
+// Source describes where the effective value of a config key came from
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// Environment variables that override config keys
+var envKeys = map[string]string{
+	"GREPTILE_AUTH_TOKEN": "AuthToken",
+	"GITHUB_TOKEN":        "GithubToken",
+	"CLIGUANA_BASE_URL":   "BaseURL",
 }
 
 func DefaultConfig() *Config {
-	authToken := os.Getenv("GREPTILE_AUTH_TOKEN")
-	if authToken == "" {
-		print("Failed to get greptile token. See readme for setup")
-		authToken = "Bearer <token>"
+	return &Config{
+		Version:          CurrentVersion,
+		BaseURL:          "https://api.greptile.com/v2",
+		Remote:           "origin",
+		Genius:           true,
+		RetryMaxAttempts: 4,
+		RetryMaxElapsed:  Duration(time.Minute),
+		ConnectTimeout:   Duration(10 * time.Second),
+		Timeout:          Duration(30 * time.Second),
+		// Genius queries on large repositories take minutes
+		QueryTimeout:  Duration(5 * time.Minute),
+		MinTLSVersion: "1.2",
+		GithubTokenProviders: []string{
+			string(SourceGitCredential),
+			string(SourceCommand),
+		},
+		ConfigFile: filepath.Join(ConfigDir(), "config.json"),
+		sources:    map[string]Source{},
+		fileValues: map[string]json.RawMessage{},
 	}
+}
 
-	githubToken := os.Getenv("GITHUB_TOKEN")
-	if githubToken == "" {
-		print("Failed to get github token. See readme for setup")
-		githubToken = "Bearer <token>"
+// LoadConfig reads the system config file and the user config file at path
+// over the defaults, then the stored credentials, and applies environment
+// overrides on top. An empty path means the default config file, which is
+// first migrated from the legacy ~/.cliguana directory if needed. A missing
+// file is not an error.
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+	if path != "" {
+		config.ConfigFile = path
+	} else if err := migrateLegacyDir(); err != nil {
+		return nil, err
 	}
 
-	return &Config{
-		AutouploadRepos: []RepoConfig{},
-		BaseURL:         "https://api.greptile.com/v2/repositories",
-		AuthToken:       authToken,
-		GithubToken:     githubToken,
-		ConfigFile:      "~/.cliguana/autoupload_repos.json",
+	// Layers from lowest to highest precedence
+	if err := config.loadLayer(SystemConfigPath(), SourceSystem); err != nil {
+		return nil, err
+	}
+	if err := config.loadLayer(config.Path(), SourceFile); err != nil {
+		return nil, err
+	}
+
+	var err error
+	config.credentials, err = LoadCredentials(config.CredentialsPath())
+	if err != nil {
+		return nil, err
+	}
+	if err := config.applyCredentials(DefaultCredentialName); err != nil {
+		return nil, fmt.Errorf("invalid credentials: %v", err)
+	}
+
+	for env, key := range envKeys {
+		if value := os.Getenv(env); value != "" {
+			if err := config.SetWithSource(key, value, SourceEnv); err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %v", env, err)
+			}
+		}
+	}
+	for env, remoteType := range remoteTokenEnvs {
+		if value := os.Getenv(env); value != "" {
+			config.SetRemoteToken(remoteType, value, SourceEnv)
+		}
 	}
-}
 
-func LoadConfig() (*Config, error) {
-	config := DefaultConfig()
 	return config, nil
 }
 
+// Source reports where the effective value of the named key came from
+func (c *Config) Source(name string) Source {
+	if source, ok := c.sources[name]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// SaveConfig writes the config to its config file. Values that came from the
+// environment, flags or a profile are not persisted; the file keeps whatever
+// it had for those keys. The file is written to a temporary file first and renamed into
+// place so a failed write never leaves a truncated config behind.
+func SaveConfig(config *Config) error {
+	current, err := toMap(config)
+	if err != nil {
+		return err
+	}
+	defaults, err := toMap(DefaultConfig())
+	if err != nil {
+		return err
+	}
+
+	persisted := map[string]json.RawMessage{}
+	for name, value := range config.fileValues {
+		persisted[name] = value
+	}
+	for name, value := range current {
+		switch config.Source(name) {
+		case SourceFile:
+			persisted[name] = value
+		case SourceDefault:
+			// Keep defaults out of the file unless they were changed in-process
+			if !bytes.Equal(value, defaults[name]) {
+				persisted[name] = value
+			} else {
+				delete(persisted, name)
+			}
+		}
+	}
+
+	persisted["Version"] = json.RawMessage(fmt.Sprint(CurrentVersion))
+
+	data, err := json.MarshalIndent(persisted, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	return writeFileAtomic(expandPath(config.ConfigFile), data, 0600)
+}
+
+// Helper to marshal a config into its top-level JSON keys
+func toMap(config *Config) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %v", err)
+	}
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %v", err)
+	}
+	return values, nil
+}
+
+// Path returns the config file path with `~` expanded
+func (c *Config) Path() string {
+	return expandPath(c.ConfigFile)
+}
+
+// Helper to write a file by renaming a temporary file in the same directory
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create config directory %s: %v", dir, err)
+	}
+
+	tmpFile, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write temp file: %v", err)
+	}
+	if err := tmpFile.Chmod(perm); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to set permissions on temp file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %v", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+	return nil
+}
+
 // Helper to expand the `~` to the user's home directory
 func expandPath(path string) string {
 	if len(path) > 1 && path[:2] == "~/" {
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 // Helper function to create a temporary config file
@@ -45,27 +48,31 @@ func TestLoadConfig_ValidFile(t *testing.T) {
 
 	configFilePath := createTempConfigFile(t, configContent)
 	defer os.Remove(configFilePath)
+	defer os.Remove(configFilePath + ".v1.bak")
 
 	// Override the default config file path
 	cfg := DefaultConfig()
 	cfg.ConfigFile = configFilePath
 
-	loadedConfig, err := LoadConfig()
+	loadedConfig, err := LoadConfig(cfg.ConfigFile)
 	if err != nil {
 		t.Fatalf("Failed to load config: %v", err)
 	}
 
-	if loadedConfig.BaseURL != "https://api.greptile.com/v2/repositories" {
-		t.Errorf("Expected BaseURL to be 'https://api.greptile.com/v2/repositories', got '%s'", loadedConfig.BaseURL)
+	// BaseURL is migrated to the API root
+	if loadedConfig.BaseURL != "https://api.greptile.com/v2" {
+		t.Errorf("Expected BaseURL to be 'https://api.greptile.com/v2', got '%s'", loadedConfig.BaseURL)
 	}
-	if loadedConfig.AuthToken != "Bearer valid_token" {
-		t.Errorf("Expected AuthToken to be 'Bearer valid_token', got '%s'", loadedConfig.AuthToken)
+	// The "Bearer " prefix is stripped since the client adds it
+	if loadedConfig.AuthToken != "valid_token" {
+		t.Errorf("Expected AuthToken to be 'valid_token', got '%s'", loadedConfig.AuthToken)
 	}
-	if loadedConfig.GithubToken != "Bearer github_token" {
-		t.Errorf("Expected GithubToken to be 'Bearer github_token', got '%s'", loadedConfig.GithubToken)
+	if loadedConfig.GithubToken != "github_token" {
+		t.Errorf("Expected GithubToken to be 'github_token', got '%s'", loadedConfig.GithubToken)
 	}
-	if len(loadedConfig.AutouploadRepos) != 1 || loadedConfig.AutouploadRepos[0].RepoPath != "/path/to/repo" {
-		t.Errorf("Expected AutouploadRepos to contain '/path/to/repo', got '%v'", loadedConfig.AutouploadRepos)
+	// AutouploadRepos is migrated into AutouploadDirs
+	if len(loadedConfig.AutouploadDirs) != 1 || loadedConfig.AutouploadDirs[0] != "/path/to/repo" {
+		t.Errorf("Expected AutouploadDirs to contain '/path/to/repo', got '%v'", loadedConfig.AutouploadDirs)
 	}
 }
 
@@ -81,13 +88,13 @@ func TestLoadConfig_NonExistentFile(t *testing.T) {
 	cfg := DefaultConfig()
 	cfg.ConfigFile = filepath.Join(os.TempDir(), "non_existent_config.json")
 
-	loadedConfig, err := LoadConfig()
+	loadedConfig, err := LoadConfig(cfg.ConfigFile)
 	if err != nil {
 		t.Fatalf("Failed to load config: %v", err)
 	}
 
-	if len(loadedConfig.AutouploadRepos) != 0 {
-		t.Errorf("Expected AutouploadRepos to be empty, got '%v'", loadedConfig.AutouploadRepos)
+	if len(loadedConfig.AutouploadDirs) != 0 {
+		t.Errorf("Expected AutouploadDirs to be empty, got '%v'", loadedConfig.AutouploadDirs)
 	}
 }
 
@@ -108,8 +115,358 @@ func TestLoadConfig_InvalidFile(t *testing.T) {
 	cfg := DefaultConfig()
 	cfg.ConfigFile = configFilePath
 
-	_, err := LoadConfig()
+	_, err := LoadConfig(cfg.ConfigFile)
 	if err == nil {
 		t.Fatalf("Expected error when loading invalid config file, got nil")
 	}
 }
+
+// Test that a saved config can be loaded back
+func TestSaveConfig_RoundTrip(t *testing.T) {
+	os.Unsetenv("GREPTILE_AUTH_TOKEN")
+	os.Unsetenv("GITHUB_TOKEN")
+
+	dir, err := ioutil.TempDir("", "cliguana_config")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := DefaultConfig()
+	cfg.ConfigFile = filepath.Join(dir, "nested", "config.json")
+	cfg.AutouploadDirs = []string{"/path/to/repo"}
+	cfg.BaseURL = "https://greptile.example.com/v2"
+	cfg.RetryMaxElapsed = Duration(30 * time.Second)
+
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	info, err := os.Stat(cfg.ConfigFile)
+	if err != nil {
+		t.Fatalf("Failed to stat saved config: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected config file mode 0600, got %v", info.Mode().Perm())
+	}
+
+	loadedConfig, err := LoadConfig(cfg.ConfigFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if loadedConfig.BaseURL != cfg.BaseURL {
+		t.Errorf("Expected BaseURL to be '%s', got '%s'", cfg.BaseURL, loadedConfig.BaseURL)
+	}
+	if loadedConfig.RetryMaxElapsed != cfg.RetryMaxElapsed {
+		t.Errorf("Expected RetryMaxElapsed to be '%s', got '%s'", cfg.RetryMaxElapsed, loadedConfig.RetryMaxElapsed)
+	}
+	if len(loadedConfig.AutouploadDirs) != 1 || loadedConfig.AutouploadDirs[0] != "/path/to/repo" {
+		t.Errorf("Expected AutouploadDirs to contain '/path/to/repo', got '%v'", loadedConfig.AutouploadDirs)
+	}
+}
+
+// Test that values from the environment are reported as such and not persisted
+func TestSaveConfig_SkipsEnvValues(t *testing.T) {
+	os.Setenv("GREPTILE_AUTH_TOKEN", "env_token")
+	defer os.Unsetenv("GREPTILE_AUTH_TOKEN")
+
+	configFilePath := createTempConfigFile(t, []byte(`{"AuthToken": "file_token"}`))
+	defer os.Remove(configFilePath)
+
+	cfg, err := LoadConfig(configFilePath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.AuthToken != "env_token" || cfg.Source("AuthToken") != SourceEnv {
+		t.Errorf("Expected AuthToken 'env_token' from env, got '%s' from %s", cfg.AuthToken, cfg.Source("AuthToken"))
+	}
+
+	if err := cfg.Set("baseurl", "https://greptile.example.com/v2/repositories"); err != nil {
+		t.Fatalf("Failed to set BaseURL: %v", err)
+	}
+	if err := cfg.Set("BaseURL", "http://greptile.example.com"); err == nil {
+		t.Errorf("Expected error when setting a non-https BaseURL")
+	}
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	os.Unsetenv("GREPTILE_AUTH_TOKEN")
+	loadedConfig, err := LoadConfig(configFilePath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if loadedConfig.AuthToken != "file_token" {
+		t.Errorf("Expected AuthToken to stay 'file_token', got '%s'", loadedConfig.AuthToken)
+	}
+	// The repositories endpoint is accepted and stored as the API root
+	if loadedConfig.BaseURL != "https://greptile.example.com/v2" || loadedConfig.Source("BaseURL") != SourceFile {
+		t.Errorf("Expected BaseURL from file, got '%s' from %s", loadedConfig.BaseURL, loadedConfig.Source("BaseURL"))
+	}
+
+	if err := loadedConfig.Unset("BaseURL"); err != nil {
+		t.Fatalf("Failed to unset BaseURL: %v", err)
+	}
+	if loadedConfig.BaseURL != DefaultConfig().BaseURL || loadedConfig.Source("BaseURL") != SourceDefault {
+		t.Errorf("Expected BaseURL to be reset to default, got '%s'", loadedConfig.BaseURL)
+	}
+}
+
+// Test selecting a profile from the repo remote
+func TestMatchProfile(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Profiles = map[string]Profile{
+		"work":    {AuthToken: "work_token", Match: []string{"github.com/acme"}},
+		"gitlab":  {Match: []string{"gitlab.*.com"}},
+		"special": {Match: []string{"github.com/acme/special"}},
+	}
+
+	tests := map[string]string{
+		"https://github.com/acme/api.git":     "work",
+		"git@github.com:acme/api.git":         "work",
+		"git@github.com:acme/special.git":     "special",
+		"https://gitlab.corp.com/team/app":    "gitlab",
+		"https://github.com/someone/else.git": "",
+	}
+	for remote, expected := range tests {
+		if name := cfg.MatchProfile(remote); name != expected {
+			t.Errorf("Expected profile '%s' for %s, got '%s'", expected, remote, name)
+		}
+	}
+
+	matched := cfg.ForRemote(context.Background(), "git@github.com:acme/api.git")
+	if matched.AuthToken != "work_token" || matched.Source("AuthToken") != profileSource("work") {
+		t.Errorf("Expected AuthToken from profile work, got '%s' from %s", matched.AuthToken, matched.Source("AuthToken"))
+	}
+	if cfg.AuthToken == "work_token" {
+		t.Errorf("Expected ForRemote to leave the original config unchanged")
+	}
+}
+
+// Test falling back to an external command for the GitHub token
+func TestResolveGithubToken_Command(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.GithubTokenProviders = []string{string(SourceCommand)}
+
+	if err := cfg.ResolveGithubToken(context.Background(), DefaultGithubHost); err == nil {
+		t.Errorf("Expected error when GithubTokenCommand is not set")
+	}
+
+	cfg.GithubTokenCommand = "echo Bearer ghp_from_command"
+	if err := cfg.ResolveGithubToken(context.Background(), DefaultGithubHost); err != nil {
+		t.Fatalf("Failed to resolve GitHub token: %v", err)
+	}
+	if cfg.GithubToken != "ghp_from_command" || cfg.Source("GithubToken") != SourceCommand {
+		t.Errorf("Expected GithubToken 'ghp_from_command' from command, got '%s' from %s", cfg.GithubToken, cfg.Source("GithubToken"))
+	}
+}
+
+// Test picking the token for a repository remote. Hosts of unknown type get
+// no token, not even the GitHub one.
+func TestTokenForRemote(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.GithubToken = "github_token"
+	cfg.SetRemoteToken("gitlab", "gitlab_token", SourceFile)
+	cfg.SetRemoteToken("GitLab.Corp.com", "Bearer corp_token", SourceCredentials)
+	cfg.SetRemoteToken("git.example.com", "example_token", SourceCredentials)
+	if err := cfg.SetWithSource("RemoteHosts", "GitLab.Corp.com=gitlab", SourceFile); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"https://github.com/owner/repo.git":      "github_token",
+		"https://gitlab.com/owner/repo.git":      "gitlab_token",
+		"git@gitlab.corp.com:owner/repo.git":     "corp_token",
+		"https://git.example.com/owner/repo.git": "",
+		"https://dev.azure.com/org/project/_git": "",
+	}
+	for remote, expected := range tests {
+		if token := cfg.TokenForRemote(remote); token != expected {
+			t.Errorf("Expected token '%s' for %s, got '%s'", expected, remote, token)
+		}
+	}
+
+	if remoteType := cfg.RemoteType("git@gitlab.corp.com:owner/repo.git"); remoteType != "gitlab" {
+		t.Errorf("Expected the mapped host to be gitlab, got '%s'", remoteType)
+	}
+	if err := cfg.SetWithSource("RemoteHosts", "git.example.com=bitbucket", SourceFile); err == nil {
+		t.Errorf("Expected an unsupported remote type to be rejected")
+	}
+}
+
+// Test applying a per-repository config file found above the repo path
+func TestForRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cliguana_repo")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	subDir := filepath.Join(dir, "src", "pkg")
+	if err := os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatalf("Failed to create repo dirs: %v", err)
+	}
+	repoConfig := []byte(`{"Remote": "upstream", "Genius": false, "Scope": ["src/", "docs/a, b.md"]}`)
+	if err := ioutil.WriteFile(filepath.Join(dir, RepoConfigFile), repoConfig, 0644); err != nil {
+		t.Fatalf("Failed to write repo config: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	layered, err := cfg.ForRepo(subDir)
+	if err != nil {
+		t.Fatalf("Failed to apply repo config: %v", err)
+	}
+	if layered.Remote != "upstream" || layered.Source("Remote") != SourceRepo {
+		t.Errorf("Expected Remote 'upstream' from repo, got '%s' from %s", layered.Remote, layered.Source("Remote"))
+	}
+	if layered.Genius || len(layered.Scope) != 2 || layered.Scope[0] != "src/" || layered.Scope[1] != "docs/a, b.md" {
+		t.Errorf("Expected Genius false and Scope [src/ docs/a, b.md], got %v and %v", layered.Genius, layered.Scope)
+	}
+	if cfg.Remote != "origin" {
+		t.Errorf("Expected ForRepo to leave the original config unchanged")
+	}
+
+	// Flags win over the repo config
+	if err := cfg.SetWithSource("Remote", "fork", SourceFlag); err != nil {
+		t.Fatal(err)
+	}
+	if layered, err = cfg.ForRepo(subDir); err != nil {
+		t.Fatalf("Failed to apply repo config: %v", err)
+	}
+	if layered.Remote != "fork" {
+		t.Errorf("Expected Remote 'fork' from the flag, got '%s'", layered.Remote)
+	}
+
+	// Endpoints and tokens can't be set from a repository
+	repoConfig = []byte(`{"BaseURL": "https://attacker.example.com"}`)
+	if err := ioutil.WriteFile(filepath.Join(dir, RepoConfigFile), repoConfig, 0644); err != nil {
+		t.Fatalf("Failed to write repo config: %v", err)
+	}
+	if _, err := cfg.ForRepo(subDir); err == nil {
+		t.Errorf("Expected error when a repo config file sets BaseURL")
+	}
+}
+
+// Test moving the legacy ~/.cliguana directory into the XDG config directory
+func TestLoadConfig_MigratesLegacyDir(t *testing.T) {
+	home, err := ioutil.TempDir("", "cliguana_home")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(home)
+
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+	os.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+
+	legacy := filepath.Join(home, ".cliguana")
+	if err := os.MkdirAll(legacy, 0700); err != nil {
+		t.Fatalf("Failed to create legacy dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(legacy, "autoupload_repos.json"), []byte(`{"AutouploadDirs": ["/path/to/repo"]}`), 0600); err != nil {
+		t.Fatalf("Failed to write legacy config: %v", err)
+	}
+
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Path() != filepath.Join(home, "xdg", "cliguana", "config.json") {
+		t.Errorf("Expected config file in XDG_CONFIG_HOME, got '%s'", cfg.Path())
+	}
+	if len(cfg.AutouploadDirs) != 1 || cfg.AutouploadDirs[0] != "/path/to/repo" {
+		t.Errorf("Expected AutouploadDirs from the legacy config, got '%v'", cfg.AutouploadDirs)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty legacy dir to be removed")
+	}
+}
+
+// Test that moving the legacy directory keeps newer files in the config
+// directory
+func TestLoadConfig_MigrationKeepsNewerFiles(t *testing.T) {
+	home, err := ioutil.TempDir("", "cliguana_home")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(home)
+
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+	os.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+
+	legacy := filepath.Join(home, ".cliguana")
+	dir := filepath.Join(home, "xdg", "cliguana")
+	for _, d := range []string{legacy, dir} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatalf("Failed to create %s: %v", d, err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(legacy, "credentials.json"), []byte(`{"default": {"AuthToken": "old_token"}}`), 0600); err != nil {
+		t.Fatalf("Failed to write legacy credentials: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "credentials.json"), []byte(`{"default": {"AuthToken": "new_token"}}`), 0600); err != nil {
+		t.Fatalf("Failed to write credentials: %v", err)
+	}
+
+	defer os.Setenv("GREPTILE_AUTH_TOKEN", os.Getenv("GREPTILE_AUTH_TOKEN"))
+	os.Unsetenv("GREPTILE_AUTH_TOKEN")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.AuthToken != "new_token" {
+		t.Errorf("Expected the newer credentials to be kept, got token '%s'", cfg.AuthToken)
+	}
+	if _, err := os.Stat(filepath.Join(legacy, "credentials.json")); err != nil {
+		t.Errorf("Expected the legacy credentials to be left in place: %v", err)
+	}
+}
+
+// Test upgrading an old config file in place and refusing a newer one
+func TestLoadConfig_Migration(t *testing.T) {
+	configContent := []byte(`{
+		"AutouploadRepos": [
+			{"repo_path": "/path/to/enabled", "status": "enabled"},
+			{"repo_path": "/path/to/disabled", "status": "disabled"}
+		],
+		"AutouploadDirs": ["/path/to/dir"],
+		"BaseURL": "https://greptile.example.com/v2/repositories",
+		"Profiles": {"work": {"BaseURL": "https://work.example.com/v2/repositories/"}}
+	}`)
+
+	configFilePath := createTempConfigFile(t, configContent)
+	defer os.Remove(configFilePath)
+	defer os.Remove(configFilePath + ".v1.bak")
+
+	cfg, err := LoadConfig(configFilePath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.AutouploadDirs) != 2 || cfg.AutouploadDirs[1] != "/path/to/enabled" {
+		t.Errorf("Expected AutouploadDirs to contain the dir and the enabled repo, got '%v'", cfg.AutouploadDirs)
+	}
+	if cfg.BaseURL != "https://greptile.example.com/v2" || cfg.Profiles["work"].BaseURL != "https://work.example.com/v2" {
+		t.Errorf("Expected BaseURLs to be migrated to API roots, got '%s' and '%s'", cfg.BaseURL, cfg.Profiles["work"].BaseURL)
+	}
+
+	backup, err := ioutil.ReadFile(configFilePath + ".v1.bak")
+	if err != nil || string(backup) != string(configContent) {
+		t.Errorf("Expected a backup of the original config file, got '%s' (%v)", backup, err)
+	}
+	upgraded, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read upgraded config: %v", err)
+	}
+	if versionOf(upgraded) != CurrentVersion {
+		t.Errorf("Expected the config file to be upgraded to version %d, got '%s'", CurrentVersion, upgraded)
+	}
+
+	newerFilePath := createTempConfigFile(t, []byte(fmt.Sprintf(`{"Version": %d}`, CurrentVersion+1)))
+	defer os.Remove(newerFilePath)
+	if _, err := LoadConfig(newerFilePath); err == nil {
+		t.Errorf("Expected error when loading a config file newer than this binary")
+	}
+}
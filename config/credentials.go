@@ -0,0 +1,102 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// SourceCredentials marks tokens read from the credentials file
+const SourceCredentials Source = "credentials"
+
+// DefaultCredentialName is the credentials entry used when no profile is active
+const DefaultCredentialName = "default"
+
+// Credential holds the tokens stored by `cliguana login`
+type Credential struct {
+	AuthToken   string `json:",omitempty"`
+	GithubToken string `json:",omitempty"`
+	// Tokens for other remotes, keyed by remote type or host
+	RemoteTokens map[string]string `json:",omitempty"`
+}
+
+// CredentialsPath returns the credentials file, kept next to the config file
+func (c *Config) CredentialsPath() string {
+	return filepath.Join(filepath.Dir(c.Path()), "credentials.json")
+}
+
+// CredentialName returns the credentials entry for the active profile
+func (c *Config) CredentialName() string {
+	if c.Profile != "" {
+		return c.Profile
+	}
+	return DefaultCredentialName
+}
+
+// LoadCredentials reads the credentials file. A missing file yields no credentials.
+func LoadCredentials(path string) (map[string]Credential, error) {
+	credentials := map[string]Credential{}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return credentials, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file %s: %v", path, err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: credentials file %s is accessible by other users; run chmod 600 on it\n", path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file %s: %v", path, err)
+	}
+	return credentials, nil
+}
+
+// SaveCredentials writes the credentials file readable only by the current user
+func SaveCredentials(path string, credentials map[string]Credential) error {
+	data, err := json.MarshalIndent(credentials, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %v", err)
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// NormalizeToken trims whitespace and any "Bearer " prefix from a token, since
+// the client adds the scheme itself
+func NormalizeToken(token string) string {
+	token = strings.TrimSpace(token)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	return token
+}
+
+// Helper to apply the stored credentials for the named entry
+func (c *Config) applyCredentials(name string) error {
+	credential, ok := c.credentials[name]
+	if !ok {
+		return nil
+	}
+	if credential.AuthToken != "" {
+		if err := c.SetWithSource("AuthToken", credential.AuthToken, SourceCredentials); err != nil {
+			return err
+		}
+	}
+	if credential.GithubToken != "" {
+		if err := c.SetWithSource("GithubToken", credential.GithubToken, SourceCredentials); err != nil {
+			return err
+		}
+	}
+	c.mergeRemoteTokens(credential.RemoteTokens, SourceCredentials)
+	return nil
+}
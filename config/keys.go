@@ -0,0 +1,367 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Key describes a config key that can be read and changed from the CLI
+type Key struct {
+	Name   string
+	Secret bool
+	get    func(c *Config) string
+	set    func(c *Config, value string) error
+}
+
+// Keys lists every config key in display order
+var Keys = []Key{
+	{
+		Name: "BaseURL",
+		get:  func(c *Config) string { return c.BaseURL },
+		set: func(c *Config, value string) error {
+			if err := validateHTTPSURL(value); err != nil {
+				return err
+			}
+			c.BaseURL = apiRoot(value)
+			return nil
+		},
+	},
+	{
+		Name:   "AuthToken",
+		Secret: true,
+		get:    func(c *Config) string { return c.AuthToken },
+		set: func(c *Config, value string) error {
+			c.AuthToken = NormalizeToken(value)
+			return nil
+		},
+	},
+	{
+		Name:   "GithubToken",
+		Secret: true,
+		get:    func(c *Config) string { return c.GithubToken },
+		set: func(c *Config, value string) error {
+			c.GithubToken = NormalizeToken(value)
+			return nil
+		},
+	},
+	{
+		Name: "GithubTokenProviders",
+		get:  func(c *Config) string { return strings.Join(c.GithubTokenProviders, ",") },
+		set: func(c *Config, value string) error {
+			providers := splitList(value)
+			for _, name := range providers {
+				if _, ok := tokenProviders[Source(name)]; !ok {
+					return fmt.Errorf("unknown provider %q, expected %s or %s", name, SourceGitCredential, SourceCommand)
+				}
+			}
+			c.GithubTokenProviders = providers
+			return nil
+		},
+	},
+	{
+		Name: "GithubTokenCommand",
+		get:  func(c *Config) string { return c.GithubTokenCommand },
+		set: func(c *Config, value string) error {
+			c.GithubTokenCommand = strings.TrimSpace(value)
+			return nil
+		},
+	},
+	{
+		Name: "RemoteHosts",
+		get: func(c *Config) string {
+			hosts := make([]string, 0, len(c.RemoteHosts))
+			for host, remoteType := range c.RemoteHosts {
+				hosts = append(hosts, host+"="+remoteType)
+			}
+			sort.Strings(hosts)
+			return strings.Join(hosts, ",")
+		},
+		set: func(c *Config, value string) error {
+			hosts := map[string]string{}
+			for _, item := range splitList(value) {
+				host, remoteType, ok := strings.Cut(item, "=")
+				host, remoteType = strings.ToLower(strings.TrimSpace(host)), strings.ToLower(strings.TrimSpace(remoteType))
+				if !ok || host == "" || !isRemoteType(remoteType) {
+					return fmt.Errorf("must be a list of host=type with type github, gitlab or azure, got %q", item)
+				}
+				hosts[host] = remoteType
+			}
+			if len(hosts) == 0 {
+				hosts = nil
+			}
+			c.RemoteHosts = hosts
+			return nil
+		},
+	},
+	{
+		Name: "Remote",
+		get:  func(c *Config) string { return c.Remote },
+		set: func(c *Config, value string) error {
+			if value == "" || strings.ContainsAny(value, " /") {
+				return fmt.Errorf("must be a git remote name, got %q", value)
+			}
+			c.Remote = value
+			return nil
+		},
+	},
+	{
+		Name: "Branch",
+		get:  func(c *Config) string { return c.Branch },
+		set: func(c *Config, value string) error {
+			c.Branch = strings.TrimSpace(value)
+			return nil
+		},
+	},
+	{
+		Name: "Genius",
+		get:  func(c *Config) string { return strconv.FormatBool(c.Genius) },
+		set: func(c *Config, value string) error {
+			genius, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("must be true or false, got %q", value)
+			}
+			c.Genius = genius
+			return nil
+		},
+	},
+	{
+		Name: "Scope",
+		get:  func(c *Config) string { return strings.Join(c.Scope, ",") },
+		set: func(c *Config, value string) error {
+			c.Scope = splitList(value)
+			return nil
+		},
+	},
+	{
+		Name: "RetryMaxAttempts",
+		get:  func(c *Config) string { return strconv.Itoa(c.RetryMaxAttempts) },
+		set: func(c *Config, value string) error {
+			attempts, err := strconv.Atoi(value)
+			if err != nil || attempts < 1 {
+				return fmt.Errorf("must be a whole number of at least 1, got %q", value)
+			}
+			c.RetryMaxAttempts = attempts
+			return nil
+		},
+	},
+	durationKey("RetryMaxElapsed", func(c *Config) *Duration { return &c.RetryMaxElapsed }),
+	durationKey("ConnectTimeout", func(c *Config) *Duration { return &c.ConnectTimeout }),
+	durationKey("Timeout", func(c *Config) *Duration { return &c.Timeout }),
+	durationKey("IndexTimeout", func(c *Config) *Duration { return &c.IndexTimeout }),
+	durationKey("InfoTimeout", func(c *Config) *Duration { return &c.InfoTimeout }),
+	durationKey("QueryTimeout", func(c *Config) *Duration { return &c.QueryTimeout }),
+	durationKey("SearchTimeout", func(c *Config) *Duration { return &c.SearchTimeout }),
+	{
+		Name:   "ProxyURL",
+		Secret: true,
+		get:    func(c *Config) string { return c.ProxyURL },
+		set: func(c *Config, value string) error {
+			if value != "" {
+				u, err := url.Parse(value)
+				if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+					return fmt.Errorf("must be an http, https or socks5 URL, got %q", Redact(value))
+				}
+			}
+			c.ProxyURL = value
+			return nil
+		},
+	},
+	{
+		Name: "CABundles",
+		get:  func(c *Config) string { return strings.Join(c.CABundles, ",") },
+		set: func(c *Config, value string) error {
+			c.CABundles = splitList(value)
+			return nil
+		},
+	},
+	{
+		Name: "ClientCert",
+		get:  func(c *Config) string { return c.ClientCert },
+		set: func(c *Config, value string) error {
+			c.ClientCert = value
+			return nil
+		},
+	},
+	{
+		Name: "ClientKey",
+		get:  func(c *Config) string { return c.ClientKey },
+		set: func(c *Config, value string) error {
+			c.ClientKey = value
+			return nil
+		},
+	},
+	{
+		Name: "MinTLSVersion",
+		get:  func(c *Config) string { return c.MinTLSVersion },
+		set: func(c *Config, value string) error {
+			if _, ok := TLSVersions[value]; !ok {
+				return fmt.Errorf("must be one of 1.0, 1.1, 1.2 or 1.3, got %q", value)
+			}
+			c.MinTLSVersion = value
+			return nil
+		},
+	},
+	{
+		Name: "AutouploadDirs",
+		get:  func(c *Config) string { return strings.Join(c.AutouploadDirs, ",") },
+		set: func(c *Config, value string) error {
+			c.AutouploadDirs = splitList(value)
+			return nil
+		},
+	},
+}
+
+// Helper to describe a key holding a duration such as "30s" or "5m"
+func durationKey(name string, field func(c *Config) *Duration) Key {
+	return Key{
+		Name: name,
+		get:  func(c *Config) string { return field(c).String() },
+		set: func(c *Config, value string) error {
+			duration, err := parseDuration(value)
+			if err != nil {
+				return err
+			}
+			*field(c) = duration
+			return nil
+		},
+	}
+}
+
+// LookupKey finds a config key by name, ignoring case
+func LookupKey(name string) (*Key, error) {
+	for i := range Keys {
+		if strings.EqualFold(Keys[i].Name, name) {
+			return &Keys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown config key: %s", name)
+}
+
+// Get returns the effective value of the named key
+func (c *Config) Get(name string) (string, error) {
+	key, err := LookupKey(name)
+	if err != nil {
+		return "", err
+	}
+	return key.get(c), nil
+}
+
+// Set validates and sets the named key so that it is persisted on save. When
+// a profile is active, keys that a profile can override are set on the profile.
+func (c *Config) Set(name string, value string) error {
+	key, err := LookupKey(name)
+	if err != nil {
+		return err
+	}
+
+	field, ok := profileKeys[key.Name]
+	if c.Profile == "" || !ok {
+		return c.SetWithSource(key.Name, value, SourceFile)
+	}
+
+	if err := c.SetWithSource(key.Name, value, profileSource(c.Profile)); err != nil {
+		return err
+	}
+	profile := c.Profiles[c.Profile]
+	*field(&profile) = key.get(c)
+	c.Profiles[c.Profile] = profile
+	c.sources["Profiles"] = SourceFile
+	return nil
+}
+
+// SetWithSource validates and sets the named key, recording where the value came from
+func (c *Config) SetWithSource(name string, value string, source Source) error {
+	key, err := LookupKey(name)
+	if err != nil {
+		return err
+	}
+	if err := key.set(c, value); err != nil {
+		return fmt.Errorf("invalid value for %s: %v", key.Name, err)
+	}
+	c.sources[key.Name] = source
+	return nil
+}
+
+// Unset restores the named key to its default and removes it from the file on
+// save. When a profile is active, the key is removed from the profile instead.
+func (c *Config) Unset(name string) error {
+	key, err := LookupKey(name)
+	if err != nil {
+		return err
+	}
+	if err := key.set(c, key.get(DefaultConfig())); err != nil {
+		return err
+	}
+
+	if field, ok := profileKeys[key.Name]; c.Profile != "" && ok {
+		profile := c.Profiles[c.Profile]
+		*field(&profile) = ""
+		c.Profiles[c.Profile] = profile
+		c.sources["Profiles"] = SourceFile
+		// Leave the top-level value in the file alone
+		c.sources[key.Name] = profileSource(c.Profile)
+		return nil
+	}
+
+	delete(c.sources, key.Name)
+	delete(c.fileValues, key.Name)
+	return nil
+}
+
+// Redact hides all but the last few characters of a secret value, or the
+// password of a URL such as a proxy
+func Redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Redacted()
+	}
+	if len(value) <= 8 {
+		return "****"
+	}
+	return "****" + value[len(value)-4:]
+}
+
+// Helper to check that a value is an absolute https URL
+func validateHTTPSURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("must be an https URL, got %q", value)
+	}
+	return nil
+}
+
+// Helper to turn a BaseURL into the API root, accepting the repositories
+// endpoint that older versions expected
+func apiRoot(value string) string {
+	value = strings.TrimSuffix(value, "/")
+	return strings.TrimSuffix(value, "/repositories")
+}
+
+// Helper to check that a value is a remote type the API supports
+func isRemoteType(value string) bool {
+	for _, remoteType := range RemoteTypes {
+		if value == remoteType {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to split a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
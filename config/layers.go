@@ -0,0 +1,178 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Sources for the system-wide and per-repository config layers
+const (
+	SourceSystem Source = "system"
+	SourceRepo   Source = "repo"
+)
+
+// RepoConfigFile is the name of the per-repository config file
+const RepoConfigFile = ".cliguana.json"
+
+// Keys a per-repository config file may set. Endpoints and tokens are left
+// out so a cloned repository can't redirect credentials elsewhere.
+var repoKeys = map[string]bool{
+	"Remote": true,
+	"Branch": true,
+	"Genius": true,
+	"Scope":  true,
+}
+
+// SystemConfigPath returns the path of the system-wide config file
+func SystemConfigPath() string {
+	if path := os.Getenv("CLIGUANA_SYSTEM_CONFIG"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "cliguana", "config.json")
+	}
+	return "/etc/cliguana/config.json"
+}
+
+// FindRepoConfig walks up from dir looking for a per-repository config file.
+// It returns an empty string when there is none.
+func FindRepoConfig(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, RepoConfigFile)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// ForRepo returns a copy of the config with the per-repository config file
+// for repoPath applied, if there is one
+func (c *Config) ForRepo(repoPath string) (*Config, error) {
+	path := FindRepoConfig(repoPath)
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repo config file %s: %v", path, err)
+	}
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse repo config file %s: %v", path, err)
+	}
+	repoConfig := &Config{}
+	if err := json.Unmarshal(data, repoConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse repo config file %s: %v", path, err)
+	}
+
+	layered := c.clone()
+	layered.RepoConfigFile = path
+	for name := range values {
+		key, err := LookupKey(name)
+		if err != nil || !repoKeys[key.Name] {
+			return nil, fmt.Errorf("repo config file %s: %s can't be set per repository", path, name)
+		}
+		// Flags win over the repo config
+		if layered.Source(key.Name) == SourceFlag {
+			continue
+		}
+		// The decoded list is kept as it is, since going through the key's
+		// comma separated form would split paths that contain commas
+		if key.Name == "Scope" {
+			layered.Scope = repoConfig.Scope
+			layered.sources[key.Name] = SourceRepo
+			continue
+		}
+		if err := layered.SetWithSource(key.Name, key.get(repoConfig), SourceRepo); err != nil {
+			return nil, fmt.Errorf("repo config file %s: %v", path, err)
+		}
+	}
+	return layered, nil
+}
+
+// Helper to decode a config file layer over the current values, migrating it
+// to the current schema first. Only the user config file is upgraded on disk
+// and remembered for saving.
+func (c *Config) loadLayer(path string, source Source) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	// JSON decoding ignores case, so use canonical names before migrating
+	fields, err := toMap(DefaultConfig())
+	if err != nil {
+		return err
+	}
+	values := map[string]json.RawMessage{}
+	for name, value := range raw {
+		for _, field := range append(legacyKeys, keysOf(fields)...) {
+			if strings.EqualFold(field, name) {
+				name = field
+				break
+			}
+		}
+		values[name] = value
+	}
+
+	migrated, err := migrate(path, values)
+	if err != nil {
+		return err
+	}
+	if migrated && source == SourceFile {
+		if err := writeMigrated(path, data, values); err != nil {
+			return err
+		}
+	}
+
+	data, err = json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	c.AuthToken = NormalizeToken(c.AuthToken)
+	c.GithubToken = NormalizeToken(c.GithubToken)
+	c.mergeRemoteTokens(c.RemoteTokens, source)
+
+	for name, value := range values {
+		if source == SourceFile {
+			c.fileValues[name] = value
+		}
+		c.sources[name] = source
+	}
+	return nil
+}
+
+// Helper to list the keys of a map
+func keysOf(values map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	return keys
+}
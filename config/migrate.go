@@ -0,0 +1,156 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// CurrentVersion is the config file schema version this binary writes.
+// Files without a version are version 1.
+const CurrentVersion = 3
+
+// Keys that only exist in older config files
+var legacyKeys = []string{"AutouploadRepos"}
+
+// Migrations upgrading a config file's top-level keys, indexed by the version
+// they upgrade from minus one
+var migrations = []func(values map[string]json.RawMessage) error{
+	migrateAutouploadRepos,
+	migrateBaseURL,
+}
+
+// Version 2 folds AutouploadRepos into AutouploadDirs, keeping only enabled repos
+func migrateAutouploadRepos(values map[string]json.RawMessage) error {
+	var dirs []string
+	if raw, ok := values["AutouploadDirs"]; ok {
+		if err := json.Unmarshal(raw, &dirs); err != nil {
+			return fmt.Errorf("invalid AutouploadDirs: %v", err)
+		}
+	}
+
+	if raw, ok := values["AutouploadRepos"]; ok {
+		var repos []struct {
+			RepoPath string `json:"repo_path"`
+			Status   string `json:"status"`
+		}
+		if err := json.Unmarshal(raw, &repos); err != nil {
+			return fmt.Errorf("invalid AutouploadRepos: %v", err)
+		}
+		for _, repo := range repos {
+			if repo.RepoPath == "" || strings.EqualFold(repo.Status, "disabled") || contains(dirs, repo.RepoPath) {
+				continue
+			}
+			dirs = append(dirs, repo.RepoPath)
+		}
+		delete(values, "AutouploadRepos")
+	}
+
+	if len(dirs) > 0 {
+		data, err := json.Marshal(dirs)
+		if err != nil {
+			return err
+		}
+		values["AutouploadDirs"] = data
+	}
+	return nil
+}
+
+// Version 3 makes BaseURL the API root rather than the repositories endpoint
+func migrateBaseURL(values map[string]json.RawMessage) error {
+	if raw, ok := values["BaseURL"]; ok {
+		var baseURL string
+		if err := json.Unmarshal(raw, &baseURL); err != nil {
+			return fmt.Errorf("invalid BaseURL: %v", err)
+		}
+		data, err := json.Marshal(apiRoot(baseURL))
+		if err != nil {
+			return err
+		}
+		values["BaseURL"] = data
+	}
+
+	if raw, ok := values["Profiles"]; ok {
+		var profiles map[string]map[string]interface{}
+		if err := json.Unmarshal(raw, &profiles); err != nil {
+			return fmt.Errorf("invalid Profiles: %v", err)
+		}
+		for _, profile := range profiles {
+			if baseURL, ok := profile["BaseURL"].(string); ok {
+				profile["BaseURL"] = apiRoot(baseURL)
+			}
+		}
+		data, err := json.Marshal(profiles)
+		if err != nil {
+			return err
+		}
+		values["Profiles"] = data
+	}
+	return nil
+}
+
+// Helper to upgrade a config file's values to the current version. It
+// reports whether anything changed.
+func migrate(path string, values map[string]json.RawMessage) (bool, error) {
+	version := 1
+	if raw, ok := values["Version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return false, fmt.Errorf("config file %s has an invalid Version: %v", path, err)
+		}
+	}
+
+	if version > CurrentVersion {
+		return false, fmt.Errorf("config file %s has version %d, but this cliguana only understands up to version %d; upgrade cliguana", path, version, CurrentVersion)
+	}
+	if version == CurrentVersion {
+		return false, nil
+	}
+
+	for ; version < CurrentVersion; version++ {
+		if err := migrations[version-1](values); err != nil {
+			return false, fmt.Errorf("failed to migrate config file %s from version %d: %v", path, version, err)
+		}
+	}
+	values["Version"] = json.RawMessage(fmt.Sprint(CurrentVersion))
+	return true, nil
+}
+
+// Helper to rewrite a migrated config file, keeping the original as a backup
+func writeMigrated(path string, original []byte, values map[string]json.RawMessage) error {
+	backup := fmt.Sprintf("%s.v%d.bak", path, versionOf(original))
+	if err := ioutil.WriteFile(backup, original, 0600); err != nil {
+		return fmt.Errorf("failed to back up config file to %s: %v", backup, err)
+	}
+
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Upgraded config file %s to version %d (backup: %s)\n", path, CurrentVersion, backup)
+	return nil
+}
+
+// Helper to read the version of a config file, defaulting to 1
+func versionOf(data []byte) int {
+	var versioned struct{ Version int }
+	if err := json.Unmarshal(data, &versioned); err != nil || versioned.Version == 0 {
+		return 1
+	}
+	return versioned.Version
+}
+
+// Helper to check whether a list contains a value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Files moved from the legacy ~/.cliguana directory, by new name
+var legacyFiles = map[string]string{
+	"config.json":      "autoupload_repos.json",
+	"credentials.json": "credentials.json",
+}
+
+// ConfigDir returns the directory for configuration and credentials,
+// $XDG_CONFIG_HOME/cliguana by default
+func ConfigDir() string {
+	return baseDir("XDG_CONFIG_HOME", ".config", windowsDir(os.UserConfigDir))
+}
+
+// CacheDir returns the directory for data that can be deleted at any time,
+// such as cached API responses, $XDG_CACHE_HOME/cliguana by default
+func CacheDir() string {
+	return baseDir("XDG_CACHE_HOME", ".cache", windowsDir(os.UserCacheDir, "cache"))
+}
+
+// StateDir returns the directory for runtime state such as the chat history
+// and sessions, $XDG_STATE_HOME/cliguana by default
+func StateDir() string {
+	return baseDir("XDG_STATE_HOME", filepath.Join(".local", "state"), windowsDir(os.UserCacheDir, "state"))
+}
+
+// Helper to resolve a base directory from its XDG variable, falling back to
+// the platform directory on Windows and the XDG default elsewhere
+func baseDir(env string, homeDefault string, windowsDefault func() (string, error)) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "cliguana")
+	}
+	if runtime.GOOS == "windows" {
+		if dir, err := windowsDefault(); err == nil {
+			return dir
+		}
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, homeDefault, "cliguana")
+}
+
+// Helper to place a directory under cliguana's folder in a Windows base
+// directory. Cache and state share %LocalAppData% in separate folders, so
+// either can be wiped on its own.
+func windowsDir(base func() (string, error), elem ...string) func() (string, error) {
+	return func() (string, error) {
+		dir, err := base()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(append([]string{dir, "cliguana"}, elem...)...), nil
+	}
+}
+
+// Helper to return the directory used before XDG support
+func legacyDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cliguana")
+}
+
+// migrateLegacyDir moves files from ~/.cliguana into the config directory.
+// Files that already exist there are newer and are never replaced.
+func migrateLegacyDir() error {
+	legacy := legacyDir()
+	if _, err := os.Stat(legacy); err != nil {
+		return nil
+	}
+
+	dir := ConfigDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create config directory %s: %v", dir, err)
+	}
+
+	moved := false
+	for name, legacyName := range legacyFiles {
+		from := filepath.Join(legacy, legacyName)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		to := filepath.Join(dir, name)
+		if _, err := os.Stat(to); err == nil {
+			continue
+		}
+		if err := os.Rename(from, to); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %v", from, dir, err)
+		}
+		moved = true
+	}
+
+	// Only remove the legacy directory if nothing else was left in it
+	os.Remove(legacy)
+
+	if moved {
+		fmt.Fprintf(os.Stderr, "Moved configuration from %s to %s\n", legacy, dir)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"cliguana/pkg/util"
+)
+
+// ProfileEnv names the environment variable that selects a profile
+const ProfileEnv = "CLIGUANA_PROFILE"
+
+// SourceRemote marks a profile that was selected by matching the repo remote
+const SourceRemote Source = "remote"
+
+// Profile holds an account's endpoint and tokens. When a profile is active its
+// non-empty values replace the top-level ones.
+type Profile struct {
+	BaseURL     string `json:",omitempty"`
+	AuthToken   string `json:",omitempty"`
+	GithubToken string `json:",omitempty"`
+	// Patterns matched against the repo remote, e.g. "github.com/acme" or "gitlab.*.com"
+	Match []string `json:",omitempty"`
+}
+
+// Keys that a profile can override
+var profileKeys = map[string]func(p *Profile) *string{
+	"BaseURL":     func(p *Profile) *string { return &p.BaseURL },
+	"AuthToken":   func(p *Profile) *string { return &p.AuthToken },
+	"GithubToken": func(p *Profile) *string { return &p.GithubToken },
+}
+
+// UseProfile activates the named profile
+func (c *Config) UseProfile(name string, source Source) error {
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile: %s", name)
+	}
+
+	c.Profile = name
+	c.sources["Profile"] = source
+	for key, field := range profileKeys {
+		if value := *field(&profile); value != "" {
+			if err := c.SetWithSource(key, value, profileSource(name)); err != nil {
+				return fmt.Errorf("profile %s: %v", name, err)
+			}
+		}
+	}
+	return c.applyCredentials(name)
+}
+
+// AddProfile creates or updates a profile with the given match patterns
+func (c *Config) AddProfile(name string, match []string) error {
+	if name == "" || strings.ContainsAny(name, " /") {
+		return fmt.Errorf("invalid profile name: %q", name)
+	}
+	for _, pattern := range match {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid match pattern %q: %v", pattern, err)
+		}
+	}
+
+	if c.Profiles == nil {
+		c.Profiles = map[string]Profile{}
+	}
+	profile := c.Profiles[name]
+	if len(match) > 0 {
+		profile.Match = match
+	}
+	c.Profiles[name] = profile
+	c.sources["Profiles"] = SourceFile
+	return nil
+}
+
+// RemoveProfile deletes a profile
+func (c *Config) RemoveProfile(name string) error {
+	if _, ok := c.Profiles[name]; !ok {
+		return fmt.Errorf("unknown profile: %s", name)
+	}
+	delete(c.Profiles, name)
+	c.sources["Profiles"] = SourceFile
+	return nil
+}
+
+// ForRemote returns the config to use for a repository with the given remote.
+// Unless a profile was selected explicitly, the profile whose match pattern
+// fits the remote best is activated on a copy of the config. A missing GitHub
+// token is then looked up from the token providers for the remote host.
+func (c *Config) ForRemote(ctx context.Context, remote string) *Config {
+	resolved := c
+	if name := c.MatchProfile(remote); c.Profile == "" && name != "" {
+		resolved = c.clone()
+		if err := resolved.UseProfile(name, SourceRemote); err != nil {
+			fmt.Fprintln(os.Stderr, "Error applying profile:", err)
+			resolved = c
+		}
+	}
+
+	if resolved.GithubToken == "" && resolved.RemoteType(remote) == "github" {
+		if resolved == c {
+			resolved = c.clone()
+		}
+		if err := resolved.ResolveGithubToken(ctx, util.GetRemoteHost(remote)); err != nil {
+			fmt.Fprintln(os.Stderr, "Warning:", err)
+		}
+	}
+	return resolved
+}
+
+// MatchProfile returns the profile with the longest pattern matching the
+// remote, or an empty string when none match
+func (c *Config) MatchProfile(remote string) string {
+	host := util.GetRemoteHost(remote)
+	repo := util.ExtractRepoName(remote)
+	if host == "" {
+		return ""
+	}
+
+	// Patterns may name a host, an owner, or a single repository
+	candidates := []string{host}
+	if repo != "" {
+		owner := strings.SplitN(repo, "/", 2)[0]
+		candidates = append(candidates, host+"/"+owner, host+"/"+repo, owner, repo)
+	}
+
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestLen := "", 0
+	for _, name := range names {
+		for _, pattern := range c.Profiles[name].Match {
+			pattern = strings.ToLower(strings.TrimSuffix(pattern, "/"))
+			for _, candidate := range candidates {
+				if ok, _ := path.Match(pattern, strings.ToLower(candidate)); ok && len(pattern) > bestLen {
+					best, bestLen = name, len(pattern)
+				}
+			}
+		}
+	}
+	return best
+}
+
+// Helper to copy a config so a profile can be applied without touching the original
+func (c *Config) clone() *Config {
+	copied := *c
+	copied.sources = map[string]Source{}
+	for key, source := range c.sources {
+		copied.sources[key] = source
+	}
+	copied.remoteTokens = map[string]string{}
+	for name, token := range c.remoteTokens {
+		copied.remoteTokens[name] = token
+	}
+	return &copied
+}
+
+// Helper to build the source reported for values taken from a profile
+func profileSource(name string) Source {
+	return Source("profile:" + name)
+}
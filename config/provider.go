@@ -0,0 +1,69 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"cliguana/pkg/util"
+)
+
+// Sources for GitHub tokens obtained from a provider
+const (
+	SourceGitCredential Source = "git-credential"
+	SourceCommand       Source = "command"
+)
+
+// DefaultGithubHost is used to look up a GitHub token when there is no repo remote
+const DefaultGithubHost = "github.com"
+
+// Token providers tried in order when no GitHub token is configured
+var tokenProviders = map[Source]func(ctx context.Context, c *Config, host string) (string, error){
+	SourceGitCredential: func(ctx context.Context, c *Config, host string) (string, error) {
+		return util.GitCredentialFill(ctx, host)
+	},
+	SourceCommand: func(ctx context.Context, c *Config, host string) (string, error) {
+		args := strings.Fields(c.GithubTokenCommand)
+		if len(args) == 0 {
+			return "", fmt.Errorf("GithubTokenCommand is not set")
+		}
+		output, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s failed: %v", c.GithubTokenCommand, err)
+		}
+		return strings.TrimSpace(string(output)), nil
+	},
+}
+
+// ResolveGithubToken fills in a missing GitHub token from the configured
+// providers for the given host. It returns why each provider failed when none
+// produced a token.
+func (c *Config) ResolveGithubToken(ctx context.Context, host string) error {
+	if c.GithubToken != "" {
+		return nil
+	}
+
+	var failures []string
+	for _, name := range c.GithubTokenProviders {
+		provider, ok := tokenProviders[Source(name)]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("%s: unknown provider", name))
+			continue
+		}
+		token, err := provider(ctx, c, host)
+		if err == nil && NormalizeToken(token) == "" {
+			err = fmt.Errorf("returned an empty token")
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		return c.SetWithSource("GithubToken", token, Source(name))
+	}
+
+	if len(failures) == 0 {
+		return fmt.Errorf("no GitHub token providers are configured")
+	}
+	return fmt.Errorf("no GitHub token found (%s)", strings.Join(failures, "; "))
+}
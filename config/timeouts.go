@@ -0,0 +1,42 @@
+package config
+
+import "time"
+
+// Operations with their own timeout keys
+const (
+	OpIndex  = "index"
+	OpInfo   = "info"
+	OpQuery  = "query"
+	OpSearch = "search"
+)
+
+// Helper to return the timeout key and value set for an operation
+func (c *Config) operationTimeout(op string) (string, Duration) {
+	switch op {
+	case OpIndex:
+		return "IndexTimeout", c.IndexTimeout
+	case OpInfo:
+		return "InfoTimeout", c.InfoTimeout
+	case OpQuery:
+		return "QueryTimeout", c.QueryTimeout
+	case OpSearch:
+		return "SearchTimeout", c.SearchTimeout
+	}
+	return "Timeout", 0
+}
+
+// TimeoutFor returns the overall deadline for an API operation, 0 for none.
+// A --timeout flag applies to every operation; otherwise the operation's own
+// timeout is used when it is set, and Timeout when it isn't.
+func (c *Config) TimeoutFor(op string) time.Duration {
+	if _, timeout := c.operationTimeout(op); timeout > 0 && c.Source("Timeout") != SourceFlag {
+		return time.Duration(timeout)
+	}
+	return time.Duration(c.Timeout)
+}
+
+// TimeoutKey names the config key that sets the timeout for an operation
+func TimeoutKey(op string) string {
+	key, _ := (&Config{}).operationTimeout(op)
+	return key
+}
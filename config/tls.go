@@ -0,0 +1,11 @@
+package config
+
+import "crypto/tls"
+
+// TLSVersions maps MinTLSVersion values to TLS versions
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
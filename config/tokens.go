@@ -0,0 +1,83 @@
+package config
+
+import (
+	"sort"
+	"strings"
+
+	"cliguana/pkg/util"
+)
+
+// Environment variables holding tokens for other remote types
+var remoteTokenEnvs = map[string]string{
+	"GITLAB_TOKEN":       "gitlab",
+	"AZURE_DEVOPS_TOKEN": "azure",
+}
+
+// SetRemoteToken sets the token used for a remote type ("gitlab", "azure") or
+// a remote host ("gitlab.example.com")
+func (c *Config) SetRemoteToken(name string, token string, source Source) {
+	if c.remoteTokens == nil {
+		c.remoteTokens = map[string]string{}
+	}
+	name = strings.ToLower(name)
+	c.remoteTokens[name] = NormalizeToken(token)
+	c.sources["RemoteTokens."+name] = source
+}
+
+// RemoteTokenNames lists the hosts and remote types that have a token, sorted
+func (c *Config) RemoteTokenNames() []string {
+	names := make([]string, 0, len(c.remoteTokens))
+	for name := range c.remoteTokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RemoteToken returns the token configured for a remote type or host
+func (c *Config) RemoteToken(name string) string {
+	return c.remoteTokens[strings.ToLower(name)]
+}
+
+// RemoteTypes lists the remote types the Greptile API supports
+var RemoteTypes = []string{"github", "gitlab", "azure"}
+
+// RemoteType returns the type of a repository remote: "github", "gitlab" or
+// "azure". Self-hosted hosts are looked up in RemoteHosts; the type of any
+// other host is unknown and returned as "".
+func (c *Config) RemoteType(remote string) string {
+	if remoteType := c.RemoteHosts[strings.ToLower(util.GetRemoteHost(remote))]; remoteType != "" {
+		return remoteType
+	}
+	return util.GetRemoteType(remote)
+}
+
+// TokenForRemote returns the token to send for a repository remote. A token
+// for the remote host wins over one for the remote type; GitHub remotes fall
+// back to GithubToken. Remotes of unknown type get no token, so a token is
+// never sent to a provider it wasn't meant for.
+func (c *Config) TokenForRemote(remote string) string {
+	remoteType := c.RemoteType(remote)
+	if remoteType == "" {
+		return ""
+	}
+	if token := c.RemoteToken(util.GetRemoteHost(remote)); token != "" {
+		return token
+	}
+	if token := c.RemoteToken(remoteType); token != "" {
+		return token
+	}
+	if remoteType == "github" {
+		return c.GithubToken
+	}
+	return ""
+}
+
+// Helper to merge remote tokens from a source over the current ones
+func (c *Config) mergeRemoteTokens(tokens map[string]string, source Source) {
+	for name, token := range tokens {
+		if token != "" {
+			c.SetRemoteToken(name, token, source)
+		}
+	}
+}
@@ -1,27 +1,128 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"cliguana/config"
+	"cliguana/pkg/auth"
+	"cliguana/pkg/doctor"
+	"cliguana/pkg/exitcode"
+	"cliguana/pkg/http/greptile"
 	"cliguana/pkg/index"
 	"cliguana/pkg/info"
+	"cliguana/pkg/list"
 	"cliguana/pkg/semantic"
+	"cliguana/pkg/session"
+	"cliguana/pkg/util"
 )
 
 func main() {
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		fmt.Println("Error loading configuration:", err)
-		os.Exit(1)
+	// Loaded after flags are parsed so global flags can override config values
+	var cfg *config.Config
+	var configFile string
+	var baseURL string
+	var timeout string
+	var profile string
+
+	// Helper to load the config and apply global flags
+	loadConfig := func() error {
+		var err error
+		cfg, err = config.LoadConfig(configFile)
+		if err != nil {
+			return fmt.Errorf("error loading configuration: %v", err)
+		}
+		// A profile from the flag wins over one from the environment
+		profileSource := config.SourceFlag
+		if profile == "" {
+			profile, profileSource = os.Getenv(config.ProfileEnv), config.SourceEnv
+		}
+		if profile != "" {
+			if err := cfg.UseProfile(profile, profileSource); err != nil {
+				return fmt.Errorf("error selecting profile: %v", err)
+			}
+		}
+		if baseURL != "" {
+			if err := cfg.SetWithSource("BaseURL", baseURL, config.SourceFlag); err != nil {
+				return fmt.Errorf("error in --base-url: %v", err)
+			}
+		}
+		if timeout != "" {
+			if err := cfg.SetWithSource("Timeout", timeout, config.SourceFlag); err != nil {
+				return fmt.Errorf("error in --timeout: %v", err)
+			}
+		}
+		return nil
 	}
 
-	var rootCmd = &cobra.Command{Use: "cliguana"}
+	// Set once a command's arguments were accepted, so errors before that
+	// are reported as usage errors
+	var started bool
+
+	// Trace of API traffic from --debug, --trace-file or $CLIGUANA_TRACE_HTTP
+	var debug bool
+	var traceFile string
+	// Completes the trace and saves a recorded cassette
+	var finishHTTP []func() error
+
+	// Helper to start the HTTP trace and the cassette recording or replay
+	// requested by flags or the environment
+	startHTTP := func() error {
+		dest := traceFile
+		if dest == "" && debug {
+			dest = "stderr"
+		}
+		if dest == "" {
+			dest = os.Getenv(greptile.TraceEnv)
+		}
+		finishTrace, err := greptile.StartTrace(dest)
+		if err != nil {
+			return fmt.Errorf("error starting HTTP trace: %v", err)
+		}
+		finishHTTP = append(finishHTTP, finishTrace)
+
+		finishCassette, err := greptile.StartCassette()
+		if err != nil {
+			return fmt.Errorf("error starting cassette: %v", err)
+		}
+		finishHTTP = append(finishHTTP, finishCassette)
+		return nil
+	}
+
+	var rootCmd = &cobra.Command{
+		Use:           "cliguana",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Cobra checks flag groups after this hook; conflicting flags are
+			// still a command line error
+			if err := cmd.ValidateFlagGroups(); err != nil {
+				return err
+			}
+			started = true
+			if err := startHTTP(); err != nil {
+				return err
+			}
+			return loadConfig()
+		},
+	}
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Path to the config file")
+	rootCmd.PersistentFlags().StringVar(&baseURL, "base-url", "", "Override the Greptile API base URL")
+	rootCmd.PersistentFlags().StringVar(&timeout, "timeout", "", "Limit every API request to this long, e.g. 90s or 10m; 0 for no limit")
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Trace every Greptile API request and response to stderr, with tokens redacted")
+	rootCmd.PersistentFlags().StringVar(&traceFile, "trace-file", "", "Write the HTTP trace to a file instead, as HAR if the name ends in .har")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Name of the config profile to use (default: $"+config.ProfileEnv+" or matched by repo remote)")
 
 	// Helper function to get absolute path
 	getAbsPath := func(repoPath string) (string, error) {
@@ -32,24 +133,50 @@ func main() {
 		return absPath, nil
 	}
 
+	// Options shared by the `index` and `clone` commands
+	var uploadOptions index.UploadOptions
+	var uploadRemoteName string
+
+	// Helper to add the index request flags to a command
+	addUploadFlags := func(cmd *cobra.Command) {
+		cmd.Flags().BoolVar(&uploadOptions.Reload, "reload", false, "Index again from scratch even if the branch is already indexed")
+		cmd.Flags().BoolVar(&uploadOptions.NoNotify, "no-notify", false, "Don't send an email when indexing finishes")
+		cmd.Flags().StringSliceVar(&uploadOptions.Branches, "branch", nil, "Branch to index instead of the checked out one (repeatable)")
+		cmd.Flags().StringVar(&uploadRemoteName, "remote-name", "", "Git remote that identifies the repository (default: the Remote config key)")
+		cmd.Flags().BoolVar(&uploadOptions.DryRun, "dry-run", false, "Print the index requests instead of sending them")
+	}
+
+	// Helper to apply --remote-name to the config
+	applyRemoteName := func() error {
+		if uploadRemoteName == "" {
+			return nil
+		}
+		if err := cfg.SetWithSource("Remote", uploadRemoteName, config.SourceFlag); err != nil {
+			return fmt.Errorf("error in --remote-name: %v", err)
+		}
+		return nil
+	}
+
 	// `clone` command to wrap git clone and automatically upload after clone
 	var cloneCmd = &cobra.Command{
 		Use:   "clone [repo_url] [repo_path]",
 		Short: "Clone a repository and automatically upload it for indexing",
 		Long:  "Clone a repository from the given URL and automatically upload it to the Greptile API for indexing.",
 		Args:  cobra.MinimumNArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			repoURL := args[0]
 			repoPath := "."
 			if len(args) > 1 {
 				repoPath = args[1]
 			}
 
-			if err := index.GitCloneAndUpload(cfg, repoURL, repoPath); err != nil {
-				fmt.Println("Error during clone and upload:", err)
+			if err := applyRemoteName(); err != nil {
+				return err
 			}
+			return index.GitCloneAndUpload(cmd.Context(), cfg, repoURL, repoPath, uploadOptions)
 		},
 	}
+	addUploadFlags(cloneCmd)
 
 	// `index` command to manually index a repository
 	var monitorProgress bool
@@ -58,52 +185,73 @@ func main() {
 		Short: "Index a specific repository",
 		Long:  "Send a repository to greptile for indexing",
 		Args:  cobra.MaximumNArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			repoPath := "."
 			if len(args) > 0 {
 				repoPath = args[0]
 			}
 			absPath, err := getAbsPath(repoPath)
 			if err != nil {
-				fmt.Println(err)
-				return
+				return err
+			}
+			if err := applyRemoteName(); err != nil {
+				return err
 			}
-			if err := index.TriggerUploadAPI(cfg, absPath); err != nil {
-				fmt.Println("Error during indexing:", err)
-				return
+			if err := index.TriggerUploadAPI(cmd.Context(), cfg, absPath, uploadOptions); err != nil {
+				return err
 			}
-			if monitorProgress {
+			if monitorProgress && !uploadOptions.DryRun {
 				//sleep for 4 seconds
-				time.Sleep(4 * time.Second)
-				if err := info.MonitorProgress(cfg, absPath); err != nil {
-					fmt.Println("Error monitoring progress:", err)
+				select {
+				case <-time.After(4 * time.Second):
+				case <-cmd.Context().Done():
+					return cmd.Context().Err()
+				}
+
+				// Monitor each requested branch in turn
+				for _, branch := range uploadOptions.Branches {
+					if err := cfg.SetWithSource("Branch", branch, config.SourceFlag); err != nil {
+						return err
+					}
+					fmt.Printf("Branch %s:\n", branch)
+					if err := info.MonitorProgress(cmd.Context(), cfg, absPath); err != nil {
+						return fmt.Errorf("error monitoring progress: %w", err)
+					}
+				}
+				if len(uploadOptions.Branches) == 0 {
+					if err := info.MonitorProgress(cmd.Context(), cfg, absPath); err != nil {
+						return fmt.Errorf("error monitoring progress: %w", err)
+					}
 				}
 			}
+			return nil
 		},
 	}
 	indexCmd.Flags().BoolVar(&monitorProgress, "monitor-progress", true, "Monitor the progress of the repository upload")
+	addUploadFlags(indexCmd)
 
-	// `unindex` command to manually index a repository
+	// `unindex` command to delete a repository's index
+	var unindexAllBranches bool
+	var unindexYes bool
 	var unindexCmd = &cobra.Command{
 		Use:   "unindex [repo_path]",
 		Short: "Unindex a specific repository",
-		Long:  "Request a repo be unindex by greptile",
+		Long:  "Delete the Greptile index of the repository's current branch, or of every branch with --all-branches. Asks for confirmation unless --yes is given, and fails if the repository isn't indexed.",
 		Args:  cobra.MaximumNArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			repoPath := "."
 			if len(args) > 0 {
 				repoPath = args[0]
 			}
 			absPath, err := getAbsPath(repoPath)
 			if err != nil {
-				fmt.Println(err)
-				return
-			}
-			if err := index.TriggerDeleteAPI(cfg, absPath); err != nil {
-				fmt.Println("Error during unindexing:", err)
+				return err
 			}
+			return index.TriggerDeleteAPI(cmd.Context(), cfg, absPath, unindexAllBranches, unindexYes)
 		},
 	}
+	unindexCmd.Flags().BoolVar(&unindexAllBranches, "all-branches", false, "Delete the index of every indexed branch of the repository")
+	unindexCmd.Flags().BoolVarP(&unindexYes, "yes", "y", false, "Delete without asking for confirmation")
 
 	// `check-progress` command to check upload progress
 	var checkProgressCmd = &cobra.Command{
@@ -111,32 +259,30 @@ func main() {
 		Short: "Check the progress of the repository upload",
 		Long:  "Check the progress of the repository upload by querying the Greptile API.",
 		Args:  cobra.MaximumNArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			repoPath := "."
 			if len(args) > 0 {
 				repoPath = args[0]
 			}
 			absPath, err := getAbsPath(repoPath)
 			if err != nil {
-				fmt.Println(err)
-				return
+				return err
 			}
 
-			filesProcessed, numFiles, err := info.CheckProgress(cfg, absPath)
+			filesProcessed, numFiles, err := info.CheckProgress(cmd.Context(), cfg, absPath)
 			if err != nil {
-				fmt.Println("Error checking progress:", err)
-				return
+				return err
 			}
 
 			// Calculate the progress percentage
 			if numFiles == 0 {
-				fmt.Println("Number of files is zero, cannot calculate progress")
-				return
+				return fmt.Errorf("number of files is zero, cannot calculate progress")
 			}
 			progress := (float64(filesProcessed) / float64(numFiles)) * 100
 
 			// Output the progress information
 			fmt.Printf("Files Processed: %d/%d (%.2f%%)\n", filesProcessed, numFiles, progress)
+			return nil
 		},
 	}
 
@@ -146,30 +292,49 @@ func main() {
 		Short: "Monitor the progress of the repository upload",
 		Long:  "Monitor the progress of the repository upload by querying the Greptile API.",
 		Args:  cobra.MaximumNArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			repoPath := "."
 			if len(args) > 0 {
 				repoPath = args[0]
 			}
 			absPath, err := getAbsPath(repoPath)
 			if err != nil {
-				fmt.Println(err)
-				return
+				return err
 			}
 
-			if err := info.MonitorProgress(cfg, absPath); err != nil {
-				fmt.Println("Error monitoring progress:", err)
+			return info.MonitorProgress(cmd.Context(), cfg, absPath)
+		},
+	}
+
+	// `list` command to show every indexed repository
+	var listFilter list.Filter
+	var listJSON bool
+	var listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List indexed repositories and their status",
+		Long:  "List the repositories indexed for your account with their branch, status, progress, sha and visibility. If the Greptile API can't list repositories, the autoupload directories are looked up instead.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repos, err := list.Repositories(cmd.Context(), cfg)
+			if err != nil {
+				return err
 			}
+			return list.Print(os.Stdout, listFilter.Apply(repos), listJSON)
 		},
 	}
+	listCmd.Flags().StringSliceVar(&listFilter.Statuses, "status", nil, "Only show repositories with this status, e.g. completed or processing (repeatable)")
+	listCmd.Flags().StringSliceVar(&listFilter.Remotes, "remote", nil, "Only show repositories on this remote type: github, gitlab or azure (repeatable)")
+	listCmd.Flags().BoolVar(&listJSON, "json", false, "Print the repositories as JSON")
 
 	// `query` command to submit a semantic query
+	var queryNoStream, queryGenius, queryFast bool
+	var queryHistory, querySession string
 	var queryCmd = &cobra.Command{
 		Use:   "query [semantic_query] [repo_path]",
 		Short: "Submit a semantic query about the codebase",
-		Long:  "Submit a natural language query about the codebase and get a natural language answer with a list of relevant code references (filepaths, line numbers, etc).",
+		Long:  "Submit a natural language query about the codebase and get a natural language answer with a list of relevant code references (filepaths, line numbers, etc). Use --history or --session to ask a follow-up question to earlier answers.",
 		Args:  cobra.MinimumNArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			semanticQuery := args[0]
 			repoPath := "."
 			if len(args) > 1 {
@@ -177,24 +342,84 @@ func main() {
 			}
 			absPath, err := getAbsPath(repoPath)
 			if err != nil {
-				fmt.Println(err)
-				return
+				return err
+			}
+
+			if queryGenius || queryFast {
+				if err := cfg.SetWithSource("Genius", fmt.Sprint(queryGenius), config.SourceFlag); err != nil {
+					return err
+				}
+			}
+
+			// Pick up the conversation the query follows on from, if any
+			var conv *greptile.Conversation
+			var saved *session.Session
+			switch {
+			case queryHistory != "":
+				conv, err = session.LoadHistory(queryHistory)
+				if err != nil {
+					return err
+				}
+			case querySession != "":
+				saved, err = session.Load(querySession)
+				if errors.Is(err, os.ErrNotExist) {
+					saved = session.New(absPath)
+					saved.SessionID = querySession
+				} else if err != nil {
+					return err
+				}
+				conv = &saved.Conversation
+			}
+
+			// Call the function to handle the query
+			if err := semantic.HandleQuery(cmd.Context(), cfg, semanticQuery, absPath, !queryNoStream, conv); err != nil {
+				return err
 			}
+			if saved != nil {
+				return session.Save(saved)
+			}
+			return nil
+		},
+	}
+	queryCmd.Flags().BoolVar(&queryNoStream, "no-stream", false, "Wait for the complete answer instead of printing it as it arrives")
+	queryCmd.Flags().BoolVar(&queryGenius, "genius", false, "Use the slower, more thorough genius mode for this query")
+	queryCmd.Flags().BoolVar(&queryFast, "fast", false, "Skip genius mode for a quicker answer to this query")
+	queryCmd.Flags().StringVar(&queryHistory, "history", "", "JSON `file` of earlier user and assistant messages the query follows on from")
+	queryCmd.Flags().StringVar(&querySession, "session", "", "Continue the saved session with this `id`, creating it if needed, and save the new turns")
+	queryCmd.MarkFlagsMutuallyExclusive("genius", "fast")
+	queryCmd.MarkFlagsMutuallyExclusive("history", "session")
 
-			// Call the function to handle the query (this function needs to be implemented)
-			if err := semantic.HandleQuery(cfg, semanticQuery, absPath); err != nil {
-				fmt.Println("Error during query:", err)
+	// `chat` command for an interactive conversation about the codebase
+	var chatOptions semantic.ChatOptions
+	var chatCmd = &cobra.Command{
+		Use:   "chat [repo_path]",
+		Short: "Chat about the codebase interactively",
+		Long:  "Ask a series of questions about the codebase at a prompt. Each question is sent with the conversation so far, so follow-up questions can refer to earlier answers. Conversations are saved as sessions that --resume continues. Type /help at the prompt for commands.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Without a path, a resumed session stays on its own checkout
+			repoPath := ""
+			if len(args) > 0 {
+				absPath, err := getAbsPath(args[0])
+				if err != nil {
+					return err
+				}
+				repoPath = absPath
 			}
+			return semantic.HandleChat(cmd.Context(), cfg, repoPath, chatOptions)
 		},
 	}
+	chatCmd.Flags().StringVar(&chatOptions.Resume, "resume", "", "Continue the saved session with this `id`")
+	chatCmd.Flags().BoolVar(&chatOptions.NoStream, "no-stream", false, "Wait for complete answers instead of printing them as they arrive")
 
 	// `search` command to submit a search query
+	var searchNoStream bool
 	var searchCmd = &cobra.Command{
 		Use:   "search [search_query] [repo_path]",
 		Short: "Submit a search query about the codebase",
 		Long:  "Submit a natural language search query about the codebase and get a list of relevant code references (filepaths, line numbers, etc).",
 		Args:  cobra.MinimumNArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			searchQuery := args[0]
 			repoPath := "."
 			if len(args) > 1 {
@@ -202,32 +427,363 @@ func main() {
 			}
 			absPath, err := getAbsPath(repoPath)
 			if err != nil {
-				fmt.Println(err)
-				return
+				return err
 			}
 
 			// Call the function to handle the search
-			if err := semantic.HandleSearch(cfg, searchQuery, absPath); err != nil {
-				fmt.Println("Error during search:", err)
-			}
+			return semantic.HandleSearch(cmd.Context(), cfg, searchQuery, absPath, !searchNoStream)
 		},
 	}
+	searchCmd.Flags().BoolVar(&searchNoStream, "no-stream", false, "Wait for all results instead of printing them as they arrive")
 
 	// `getEnabledDirectories` command to print the list of enabled directories
 	var getEnabledDirsCmd = &cobra.Command{
 		Use:   "autoindex-list",
 		Short: "Print the list of enabled directories",
 		Long:  "Print the list of directories that are enabled for autoupload.",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(cfg.AutouploadDirs) == 0 {
 				fmt.Println("No directories are enabled for autoupload.")
-				return
+				return nil
 			}
 
 			fmt.Println("Enabled directories for autoupload:")
 			for _, dir := range cfg.AutouploadDirs {
 				fmt.Println(dir)
 			}
+			return nil
+		},
+	}
+
+	// `config` command group to inspect and change the configuration
+	var showSecrets bool
+	var configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Inspect and change the configuration",
+		Long:  "Inspect and change the cliguana configuration stored in the config file.",
+	}
+	configCmd.PersistentFlags().BoolVar(&showSecrets, "show-secrets", false, "Print tokens without redaction")
+
+	// Helper to format a key's value for output
+	displayValue := func(key config.Key, value string) string {
+		if key.Secret && !showSecrets {
+			return config.Redact(value)
+		}
+		return value
+	}
+
+	var listRepoPath string
+	var configListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List effective configuration values",
+		Long:  "List every configuration key with its effective value and where it came from (default, system, file, repo, credentials, env, profile, flag). With --repo, include the repository's .cliguana.json and matching profile.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			listCfg := cfg
+			if listRepoPath != "" {
+				absPath, err := getAbsPath(listRepoPath)
+				if err != nil {
+					return err
+				}
+				listCfg, err = cfg.ForRepo(absPath)
+				if err != nil {
+					return fmt.Errorf("error loading repo config: %w", err)
+				}
+				if remote := util.GetRemoteUrl(cmd.Context(), absPath, listCfg.Remote); remote != "" {
+					listCfg = listCfg.ForRemote(cmd.Context(), remote)
+				}
+			}
+
+			fmt.Println("System config:", config.SystemConfigPath())
+			fmt.Println("User config:", listCfg.Path())
+			if listCfg.RepoConfigFile != "" {
+				fmt.Println("Repo config:", listCfg.RepoConfigFile)
+			}
+			fmt.Println()
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+			for _, key := range config.Keys {
+				value, _ := listCfg.Get(key.Name)
+				fmt.Fprintf(w, "%s\t%s\t%s\n", key.Name, displayValue(key, value), listCfg.Source(key.Name))
+			}
+			if listCfg.Profile != "" {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", "Profile", listCfg.Profile, listCfg.Source("Profile"))
+			}
+			return w.Flush()
+		},
+	}
+	configListCmd.Flags().StringVar(&listRepoPath, "repo", "", "Include the config layers of the repository at this path")
+
+	var configGetCmd = &cobra.Command{
+		Use:   "get [key]",
+		Short: "Print the effective value of a configuration key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := config.LookupKey(args[0])
+			if err != nil {
+				return err
+			}
+			value, _ := cfg.Get(key.Name)
+			fmt.Println(displayValue(*key, value))
+			return nil
+		},
+	}
+
+	var configSetCmd = &cobra.Command{
+		Use:   "set [key] [value]",
+		Short: "Set a configuration key in the config file",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Set(args[0], args[1]); err != nil {
+				return fmt.Errorf("error setting config: %w", err)
+			}
+			if err := config.SaveConfig(cfg); err != nil {
+				return fmt.Errorf("error saving config: %w", err)
+			}
+			return nil
+		},
+	}
+
+	var configUnsetCmd = &cobra.Command{
+		Use:   "unset [key]",
+		Short: "Remove a configuration key from the config file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Unset(args[0]); err != nil {
+				return fmt.Errorf("error unsetting config: %w", err)
+			}
+			if err := config.SaveConfig(cfg); err != nil {
+				return fmt.Errorf("error saving config: %w", err)
+			}
+			return nil
+		},
+	}
+
+	var showCacheDir, showStateDir bool
+	var configPathCmd = &cobra.Command{
+		Use:   "path",
+		Short: "Print the config file path",
+		Long:  "Print the config file path, or with --cache or --state the directory for data that is safe to delete or for runtime state such as the chat history and sessions.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case showCacheDir:
+				fmt.Println(config.CacheDir())
+			case showStateDir:
+				fmt.Println(config.StateDir())
+			default:
+				fmt.Println(cfg.Path())
+			}
+			return nil
+		},
+	}
+	configPathCmd.Flags().BoolVar(&showCacheDir, "cache", false, "Print the cache directory")
+	configPathCmd.Flags().BoolVar(&showStateDir, "state", false, "Print the state directory")
+
+	// `config profile` commands to manage named profiles
+	var configProfileCmd = &cobra.Command{
+		Use:   "profile",
+		Short: "Manage named profiles",
+		Long:  "Manage named profiles holding separate Greptile and GitHub credentials. Set profile values with `cliguana --profile NAME config set KEY VALUE`.",
+	}
+
+	var configProfileListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List profiles and their match patterns",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(cfg.Profiles) == 0 {
+				fmt.Println("No profiles are configured.")
+				return nil
+			}
+			names := make([]string, 0, len(cfg.Profiles))
+			for name := range cfg.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tBASE URL\tMATCH")
+			for _, name := range names {
+				p := cfg.Profiles[name]
+				fmt.Fprintf(w, "%s\t%s\t%s\n", name, p.BaseURL, strings.Join(p.Match, ","))
+			}
+			return w.Flush()
+		},
+	}
+
+	var profileMatch []string
+	var configProfileAddCmd = &cobra.Command{
+		Use:   "add [name]",
+		Short: "Create a profile or update its match patterns",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.AddProfile(args[0], profileMatch); err != nil {
+				return fmt.Errorf("error adding profile: %w", err)
+			}
+			if err := config.SaveConfig(cfg); err != nil {
+				return fmt.Errorf("error saving config: %w", err)
+			}
+			return nil
+		},
+	}
+	configProfileAddCmd.Flags().StringSliceVar(&profileMatch, "match", nil, "Remote host, owner or repository pattern that selects this profile (repeatable)")
+
+	var configProfileRemoveCmd = &cobra.Command{
+		Use:   "remove [name]",
+		Short: "Delete a profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.RemoveProfile(args[0]); err != nil {
+				return fmt.Errorf("error removing profile: %w", err)
+			}
+			if err := config.SaveConfig(cfg); err != nil {
+				return fmt.Errorf("error saving config: %w", err)
+			}
+			return nil
+		},
+	}
+
+	configProfileCmd.AddCommand(configProfileListCmd)
+	configProfileCmd.AddCommand(configProfileAddCmd)
+	configProfileCmd.AddCommand(configProfileRemoveCmd)
+
+	configCmd.AddCommand(configListCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configUnsetCmd)
+	configCmd.AddCommand(configPathCmd)
+	configCmd.AddCommand(configProfileCmd)
+
+	// `login` command to store tokens in the credentials file
+	var loginRemote string
+	var loginCmd = &cobra.Command{
+		Use:   "login",
+		Short: "Store Greptile and GitHub tokens",
+		Long:  "Prompt for Greptile and GitHub tokens and store them in the credentials file for the active profile. With --remote, store the token for a GitLab or Azure DevOps remote type or host instead.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if loginRemote != "" {
+				if err := auth.LoginRemote(cmd.Context(), cfg, loginRemote); err != nil {
+					return fmt.Errorf("error during login: %w", err)
+				}
+				return nil
+			}
+			if err := auth.Login(cmd.Context(), cfg); err != nil {
+				return fmt.Errorf("error during login: %w", err)
+			}
+			return nil
+		},
+	}
+
+	loginCmd.Flags().StringVar(&loginRemote, "remote", "", "Remote type (gitlab, azure) or host to store a token for")
+
+	// `logout` command to remove stored tokens
+	var logoutAll bool
+	var logoutCmd = &cobra.Command{
+		Use:   "logout",
+		Short: "Remove stored tokens",
+		Long:  "Remove the tokens stored in the credentials file for the active profile.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := auth.Logout(cfg, logoutAll); err != nil {
+				return fmt.Errorf("error during logout: %w", err)
+			}
+			return nil
+		},
+	}
+	logoutCmd.Flags().BoolVar(&logoutAll, "all", false, "Remove the stored tokens of every profile")
+
+	// `auth status` command to validate the configured tokens
+	var authCmd = &cobra.Command{
+		Use:   "auth",
+		Short: "Manage authentication",
+	}
+
+	var authStatusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Validate the configured tokens",
+		Long:  "Report where each token came from and check it against the Greptile and GitHub APIs.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			failed := false
+			for _, status := range auth.Status(cmd.Context(), cfg) {
+				switch {
+				case !status.Present:
+					fmt.Printf("%s token: not configured", status.Name)
+					if status.Detail != "" {
+						fmt.Printf(": %s", status.Detail)
+					}
+					fmt.Println()
+					failed = failed || status.Name == "Greptile"
+				case status.Valid:
+					fmt.Printf("%s token: valid (source: %s)", status.Name, status.Source)
+					if status.Detail != "" {
+						fmt.Printf(", logged in as %s", status.Detail)
+					}
+					fmt.Println()
+				case status.Rejected:
+					fmt.Printf("%s token: invalid (source: %s): %s\n", status.Name, status.Source, status.Detail)
+					failed = true
+				default:
+					fmt.Printf("%s token: could not be validated (source: %s): %s\n", status.Name, status.Source, status.Detail)
+				}
+			}
+			for _, name := range cfg.RemoteTokenNames() {
+				fmt.Printf("Token for %s: configured (source: %s)\n", name, cfg.Source("RemoteTokens."+name))
+			}
+			if failed {
+				return exitcode.WithCode(exitcode.Auth, errors.New("a token is missing or was rejected"))
+			}
+			return nil
+		},
+	}
+	authCmd.AddCommand(authStatusCmd)
+
+	// `doctor` command to diagnose the environment
+	var loadErr error
+	var doctorCmd = &cobra.Command{
+		Use:   "doctor [repo_path]",
+		Short: "Diagnose the cliguana environment",
+		Long:  "Check git, the repository's remote and branch, tokens, API reachability and config files, and print a pass/warn/fail report with hints.",
+		Args:  cobra.MaximumNArgs(1),
+		// Keep going when the config can't be loaded so it can be reported
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			started = true
+			if err := startHTTP(); err != nil {
+				return err
+			}
+			if loadErr = loadConfig(); loadErr != nil {
+				cfg = config.DefaultConfig()
+				if configFile != "" {
+					cfg.ConfigFile = configFile
+				}
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath := "."
+			if len(args) > 0 {
+				repoPath = args[0]
+			}
+			absPath, err := getAbsPath(repoPath)
+			if err != nil {
+				return err
+			}
+
+			failed := false
+			for _, check := range doctor.Run(cmd.Context(), cfg, loadErr, absPath) {
+				fmt.Printf("[%s] %s: %s\n", check.Status, check.Name, check.Message)
+				if check.Hint != "" {
+					fmt.Printf("       hint: %s\n", check.Hint)
+				}
+				failed = failed || check.Status == doctor.Fail
+			}
+			if failed {
+				return errors.New("some checks failed")
+			}
+			return nil
 		},
 	}
 
@@ -236,12 +792,48 @@ func main() {
 	rootCmd.AddCommand(cloneCmd)
 	rootCmd.AddCommand(checkProgressCmd)
 	rootCmd.AddCommand(monitorProgressCmd)
+	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(queryCmd)
+	rootCmd.AddCommand(chatCmd)
 	rootCmd.AddCommand(searchCmd)
 	rootCmd.AddCommand(getEnabledDirsCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(loginCmd)
+	rootCmd.AddCommand(logoutCmd)
+	rootCmd.AddCommand(authCmd)
+	rootCmd.AddCommand(doctorCmd)
+
+	// Ctrl-C or SIGTERM cancels the running command; a second one kills the
+	// process immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println("Error executing command:", err)
-		os.Exit(1)
+	cmd, err := rootCmd.ExecuteContextC(ctx)
+	for _, finish := range finishHTTP {
+		if finishErr := finish(); finishErr != nil {
+			fmt.Fprintln(os.Stderr, "Warning:", finishErr)
+		}
+	}
+	if ctx.Err() != nil {
+		// Finish any half-drawn line such as a progress bar before reporting
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintf(os.Stderr, "Interrupted %s", cmd.CommandPath())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, ": %v", err)
+		}
+		fmt.Fprintln(os.Stderr)
+		os.Exit(exitcode.Interrupted)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		if !started {
+			// Cobra rejected the command line before running anything
+			fmt.Fprintf(os.Stderr, "Run '%s --help' for usage.\n", cmd.CommandPath())
+			os.Exit(exitcode.Usage)
+		}
+		os.Exit(exitcode.For(err))
 	}
 }
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"cliguana/config"
+	"cliguana/pkg/http/github"
+	"cliguana/pkg/http/greptile"
+	"cliguana/pkg/util"
+)
+
+// TokenStatus describes one configured token and whether the API accepted it
+type TokenStatus struct {
+	Name    string
+	Source  config.Source
+	Present bool
+	Valid   bool
+	// Rejected is set when the API refused the token, as opposed to being unreachable
+	Rejected bool
+	// Detail is the GitHub login for a valid token or the validation error otherwise
+	Detail string
+}
+
+// Login prompts for tokens and stores them in the credentials file under the
+// active profile
+func Login(ctx context.Context, cfg *config.Config) error {
+	authToken, err := util.ReadSecret(ctx, "Greptile API token: ")
+	if err != nil {
+		return err
+	}
+	authToken = config.NormalizeToken(authToken)
+	if authToken == "" {
+		return fmt.Errorf("a Greptile API token is required")
+	}
+
+	githubToken, err := util.ReadSecret(ctx, "GitHub token (leave empty to skip): ")
+	if err != nil {
+		return err
+	}
+	githubToken = config.NormalizeToken(githubToken)
+
+	path := cfg.CredentialsPath()
+	credentials, err := config.LoadCredentials(path)
+	if err != nil {
+		return err
+	}
+
+	name := cfg.CredentialName()
+	// Keep any remote tokens stored for this profile
+	credential := credentials[name]
+	credential.AuthToken = authToken
+	credential.GithubToken = githubToken
+	credentials[name] = credential
+	if err := config.SaveCredentials(path, credentials); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saved credentials for %s to %s\n", name, path)
+	return nil
+}
+
+// LoginRemote prompts for the token of a remote type ("gitlab", "azure") or
+// host and stores it in the credentials file under the active profile
+func LoginRemote(ctx context.Context, cfg *config.Config, remote string) error {
+	token, err := util.ReadSecret(ctx, fmt.Sprintf("Token for %s: ", remote))
+	if err != nil {
+		return err
+	}
+	token = config.NormalizeToken(token)
+	if token == "" {
+		return fmt.Errorf("a token for %s is required", remote)
+	}
+
+	path := cfg.CredentialsPath()
+	credentials, err := config.LoadCredentials(path)
+	if err != nil {
+		return err
+	}
+
+	name := cfg.CredentialName()
+	credential := credentials[name]
+	if credential.RemoteTokens == nil {
+		credential.RemoteTokens = map[string]string{}
+	}
+	credential.RemoteTokens[strings.ToLower(remote)] = token
+	credentials[name] = credential
+	if err := config.SaveCredentials(path, credentials); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saved %s token for %s to %s\n", remote, name, path)
+
+	// A host's token is only sent once its remote type is known
+	if strings.Contains(remote, ".") && cfg.RemoteType("https://"+remote+"/") == "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s isn't a known GitHub, GitLab or Azure DevOps host; set its type with `cliguana config set RemoteHosts %s=gitlab` so the token is used\n", remote, strings.ToLower(remote))
+	}
+	return nil
+}
+
+// Logout removes the stored credentials for the active profile, or for every
+// profile when all is set
+func Logout(cfg *config.Config, all bool) error {
+	path := cfg.CredentialsPath()
+	credentials, err := config.LoadCredentials(path)
+	if err != nil {
+		return err
+	}
+
+	name := cfg.CredentialName()
+	if all {
+		credentials = map[string]config.Credential{}
+	} else if _, ok := credentials[name]; !ok {
+		fmt.Printf("No stored credentials for %s.\n", name)
+		return nil
+	} else {
+		delete(credentials, name)
+	}
+
+	if err := config.SaveCredentials(path, credentials); err != nil {
+		return err
+	}
+
+	if all {
+		fmt.Println("Removed all stored credentials.")
+	} else {
+		fmt.Printf("Removed stored credentials for %s.\n", name)
+	}
+	return nil
+}
+
+// Presence reports where each token came from without validating it, so it
+// works offline
+func Presence(ctx context.Context, cfg *config.Config) []TokenStatus {
+	greptileStatus := TokenStatus{
+		Name:    "Greptile",
+		Source:  cfg.Source("AuthToken"),
+		Present: cfg.AuthToken != "",
+	}
+
+	// Report the provider that would supply the GitHub token
+	var resolveErr error
+	if cfg.GithubToken == "" {
+		resolveErr = cfg.ResolveGithubToken(ctx, config.DefaultGithubHost)
+	}
+
+	githubStatus := TokenStatus{
+		Name:    "GitHub",
+		Source:  cfg.Source("GithubToken"),
+		Present: cfg.GithubToken != "",
+	}
+	if resolveErr != nil {
+		githubStatus.Detail = resolveErr.Error()
+	}
+
+	return []TokenStatus{greptileStatus, githubStatus}
+}
+
+// Status reports where each token came from and validates it against its API
+func Status(ctx context.Context, cfg *config.Config) []TokenStatus {
+	statuses := Presence(ctx, cfg)
+
+	greptileStatus := &statuses[0]
+	if greptileStatus.Present {
+		if err := greptile.NewClient(cfg).ValidateToken(ctx); err != nil {
+			greptileStatus.Detail = err.Error()
+			greptileStatus.Rejected = errors.Is(err, greptile.ErrInvalidToken)
+		} else {
+			greptileStatus.Valid = true
+		}
+	}
+
+	githubStatus := &statuses[1]
+	if githubStatus.Present {
+		if login, err := github.ValidateToken(ctx, cfg, cfg.GithubToken); err != nil {
+			githubStatus.Detail = err.Error()
+			githubStatus.Rejected = err == github.ErrInvalidToken
+		} else {
+			githubStatus.Valid = true
+			githubStatus.Detail = login
+		}
+	}
+
+	return statuses
+}
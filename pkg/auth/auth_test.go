@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cliguana/config"
+	"cliguana/pkg/http/github"
+)
+
+// Test validating tokens against stand-in Greptile and GitHub servers
+func TestStatus(t *testing.T) {
+	greptileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer greptileServer.Close()
+
+	githubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user" || r.Header.Get("Authorization") != "Bearer ghp_good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"login": "octocat"}`))
+	}))
+	defer githubServer.Close()
+	github.APIURL = githubServer.URL
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = greptileServer.URL
+	cfg.AuthToken = "good_token"
+	cfg.GithubToken = "ghp_good"
+
+	statuses := Status(context.Background(), cfg)
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 token statuses, got %d", len(statuses))
+	}
+	if !statuses[0].Valid {
+		t.Errorf("Expected Greptile token to be valid, got '%s'", statuses[0].Detail)
+	}
+	if !statuses[1].Valid || statuses[1].Detail != "octocat" {
+		t.Errorf("Expected GitHub token to be valid for octocat, got '%s'", statuses[1].Detail)
+	}
+
+	cfg.AuthToken = "bad_token"
+	cfg.GithubToken = ""
+	cfg.GithubTokenProviders = nil
+	statuses = Status(context.Background(), cfg)
+	if statuses[0].Valid || !statuses[0].Rejected {
+		t.Errorf("Expected Greptile token to be invalid")
+	}
+	if statuses[1].Present {
+		t.Errorf("Expected GitHub token to be reported as not configured")
+	}
+}
@@ -0,0 +1,200 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"cliguana/config"
+)
+
+// Helper to create a config with its files in dir, talking to a stand-in
+// Greptile API that answers token checks with status. The server must be
+// closed.
+func newConfig(dir string, status int) (*config.Config, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`[]`))
+	}))
+
+	cfg := config.DefaultConfig()
+	cfg.ConfigFile = filepath.Join(dir, "config", "config.json")
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "test_token"
+	cfg.GithubTokenProviders = nil
+	cfg.RetryMaxAttempts = 1
+	return cfg, server
+}
+
+// Helper to find a check by name
+func findCheck(t *testing.T, checks []Check, name string) Check {
+	for _, check := range checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	t.Fatalf("Expected a %q check, got %+v", name, checks)
+	return Check{}
+}
+
+// Helper to create a temp dir holding a git repository without any remote
+// in repo/ and room for config files in config/
+func newRepo(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "cliguana-doctor")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "config"), 0700); err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+
+	repoPath := filepath.Join(dir, "repo")
+	if output, err := exec.Command("git", "init", "-q", repoPath).CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		t.Skipf("git is not available: %v: %s", err, output)
+	}
+	return dir, repoPath
+}
+
+// Test that a config file that failed to load is reported
+func TestRun_ConfigLoadFailure(t *testing.T) {
+	dir, repoPath := newRepo(t)
+	defer os.RemoveAll(dir)
+	cfg, server := newConfig(dir, http.StatusOK)
+	defer server.Close()
+
+	checks := Run(context.Background(), cfg, errors.New("invalid character in config.json"), repoPath)
+	check := findCheck(t, checks, "Config file")
+	if check.Status != Fail || !strings.Contains(check.Message, "invalid character") {
+		t.Errorf("Expected the config file to fail with the load error, got %+v", check)
+	}
+}
+
+// Test that readable config and credentials files are flagged
+func TestRun_Permissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not checked on Windows")
+	}
+	dir, repoPath := newRepo(t)
+	defer os.RemoveAll(dir)
+	cfg, server := newConfig(dir, http.StatusOK)
+	defer server.Close()
+
+	checks := Run(context.Background(), cfg, nil, repoPath)
+	if check := findCheck(t, checks, "Config file"); check.Status != Pass || !strings.Contains(check.Message, "does not exist") {
+		t.Errorf("Expected a missing config file to pass, got %+v", check)
+	}
+
+	for _, path := range []string{cfg.Path(), cfg.CredentialsPath()} {
+		if err := ioutil.WriteFile(path, []byte(`{}`), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+		// WriteFile's mode is subject to the umask
+		if err := os.Chmod(path, 0644); err != nil {
+			t.Fatalf("Failed to chmod %s: %v", path, err)
+		}
+	}
+
+	checks = Run(context.Background(), cfg, nil, repoPath)
+	if check := findCheck(t, checks, "Config file permissions"); check.Status != Warn || check.Hint == "" {
+		t.Errorf("Expected a readable config file to warn, got %+v", check)
+	}
+	if check := findCheck(t, checks, "Credentials file permissions"); check.Status != Fail || check.Hint == "" {
+		t.Errorf("Expected a readable credentials file to fail, got %+v", check)
+	}
+
+	for _, path := range []string{cfg.Path(), cfg.CredentialsPath()} {
+		os.Chmod(path, 0600)
+	}
+	checks = Run(context.Background(), cfg, nil, repoPath)
+	for _, name := range []string{"Config file permissions", "Credentials file permissions"} {
+		if check := findCheck(t, checks, name); check.Status != Pass {
+			t.Errorf("Expected private files to pass, got %+v", check)
+		}
+	}
+}
+
+// Test that a missing remote fails and the API is still checked
+func TestRun_MissingRemote(t *testing.T) {
+	dir, repoPath := newRepo(t)
+	defer os.RemoveAll(dir)
+	cfg, server := newConfig(dir, http.StatusOK)
+	defer server.Close()
+
+	checks := Run(context.Background(), cfg, nil, repoPath)
+	check := findCheck(t, checks, "Remote")
+	if check.Status != Fail || !strings.Contains(check.Message, `remote "origin" not found`) {
+		t.Errorf("Expected the missing remote to fail, got %+v", check)
+	}
+	if check := findCheck(t, checks, "Greptile API"); check.Status != Pass {
+		t.Errorf("Expected the API to be checked after the remote, got %+v", check)
+	}
+}
+
+// Test how the answer to the token check is reported
+func TestRun_Tokens(t *testing.T) {
+	tests := []struct {
+		status  int
+		want    Status
+		message string
+	}{
+		{http.StatusOK, Pass, "valid"},
+		{http.StatusUnauthorized, Fail, "rejected"},
+		{http.StatusNotFound, Warn, "could not be validated"},
+	}
+
+	dir, repoPath := newRepo(t)
+	defer os.RemoveAll(dir)
+
+	for _, test := range tests {
+		cfg, server := newConfig(dir, test.status)
+
+		checks := Run(context.Background(), cfg, nil, repoPath)
+		server.Close()
+		check := findCheck(t, checks, "Greptile token")
+		if check.Status != test.want || !strings.HasPrefix(check.Message, test.message) {
+			t.Errorf("Expected a %d answer to report %s %q, got %+v", test.status, test.want, test.message, check)
+		}
+		if check := findCheck(t, checks, "GitHub token"); check.Status != Warn || !strings.HasPrefix(check.Message, "not configured") {
+			t.Errorf("Expected a missing GitHub token to warn, got %+v", check)
+		}
+	}
+}
+
+// Test that token presence is reported when the API can't be reached
+func TestRun_Unreachable(t *testing.T) {
+	dir, repoPath := newRepo(t)
+	defer os.RemoveAll(dir)
+	cfg, server := newConfig(dir, http.StatusOK)
+	server.Close()
+	cfg.AuthToken = ""
+
+	checks := Run(context.Background(), cfg, nil, repoPath)
+	if check := findCheck(t, checks, "Greptile API"); check.Status != Fail {
+		t.Errorf("Expected the unreachable API to fail, got %+v", check)
+	}
+	if check := findCheck(t, checks, "Greptile token"); check.Status != Fail || check.Message != "not configured" {
+		t.Errorf("Expected the missing Greptile token to be reported, got %+v", check)
+	}
+	if check := findCheck(t, checks, "GitHub token"); check.Status != Warn || !strings.HasPrefix(check.Message, "not configured") {
+		t.Errorf("Expected the missing GitHub token to be reported, got %+v", check)
+	}
+
+	cfg.AuthToken = "test_token"
+	checks = Run(context.Background(), cfg, nil, repoPath)
+	if check := findCheck(t, checks, "Greptile token"); check.Status != Warn || !strings.Contains(check.Message, "not validated") {
+		t.Errorf("Expected the Greptile token to be reported as unvalidated, got %+v", check)
+	}
+}
@@ -0,0 +1,76 @@
+package exitcode
+
+import (
+	"errors"
+
+	"cliguana/pkg/http/greptile"
+)
+
+// Process exit codes, documented in the README
+const (
+	Success     = 0
+	Failure     = 1
+	Usage       = 2
+	Auth        = 3
+	NotFound    = 4
+	RateLimited = 5
+	ServerError = 6
+	Network     = 7
+	Timeout     = 8
+	// Interrupted by Ctrl-C or SIGTERM, following the shell convention of
+	// 128 plus the signal number
+	Interrupted = 130
+)
+
+// ExitError is an error that should end the process with a specific code
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// WithCode attaches an exit code to an error
+func WithCode(code int, err error) error {
+	return &ExitError{Code: code, Err: err}
+}
+
+// For returns the exit code for an error returned by a command
+func For(err error) int {
+	if err == nil {
+		return Success
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	if errors.Is(err, greptile.ErrMissingToken) {
+		return Auth
+	}
+
+	var apiErr *greptile.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Kind {
+		case greptile.KindAuth:
+			return Auth
+		case greptile.KindNotFound:
+			return NotFound
+		case greptile.KindRateLimit:
+			return RateLimited
+		case greptile.KindServer:
+			return ServerError
+		case greptile.KindNetwork:
+			return Network
+		case greptile.KindTimeout:
+			return Timeout
+		}
+	}
+	return Failure
+}
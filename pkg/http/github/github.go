@@ -0,0 +1,91 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cliguana/config"
+	"cliguana/pkg/http/greptile"
+)
+
+// APIURL is the root of the GitHub REST API
+var APIURL = "https://api.github.com"
+
+// ErrInvalidToken is returned when GitHub rejects a token
+var ErrInvalidToken = errors.New("token was rejected by GitHub")
+
+// Longest time to wait for GitHub to answer
+const timeout = 30 * time.Second
+
+// ValidateToken checks a token against the GitHub API and returns the login
+// it belongs to. The request uses the proxy and TLS settings in the config,
+// like requests to the Greptile API.
+func ValidateToken(ctx context.Context, cfg *config.Config, token string) (string, error) {
+	transport, err := greptile.NewTransport(cfg)
+	if err != nil {
+		return "", err
+	}
+	httpClient := &http.Client{Transport: transport, Timeout: timeout}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", APIURL+"/user", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Accept", "application/vnd.github+json")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to make the request: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+
+	// Only a 401 means the token is bad. GitHub also answers 403 for rate
+	// limits and SSO or permission blocks, which leave a token unvalidated.
+	if res.StatusCode == http.StatusUnauthorized {
+		return "", ErrInvalidToken
+	}
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("GitHub answered %s: %s", res.Status, describeRefusal(res, body))
+	}
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := json.Unmarshal(body, &user); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	return user.Login, nil
+}
+
+// Helper to describe why GitHub refused a request, from its rate limit
+// headers or the message in the response body
+func describeRefusal(res *http.Response, body []byte) string {
+	if res.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return fmt.Sprintf("rate limit exceeded until %s", time.Unix(reset, 0).Format("15:04:05"))
+		}
+		return "rate limit exceeded"
+	}
+
+	var message struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &message); err == nil && message.Message != "" {
+		return message.Message
+	}
+	return strings.TrimSpace(string(body))
+}
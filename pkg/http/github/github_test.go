@@ -0,0 +1,90 @@
+package github
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cliguana/config"
+)
+
+// Test that token validation trusts the configured CA bundles
+func TestValidateToken_CABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer github_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"login": "octocat"}`))
+	}))
+	defer server.Close()
+	defer func(url string) { APIURL = url }(APIURL)
+	APIURL = server.URL
+
+	cfg := config.DefaultConfig()
+	if _, err := ValidateToken(context.Background(), cfg, "github_token"); err == nil {
+		t.Fatalf("Expected the test server's certificate to be untrusted")
+	}
+
+	dir, err := ioutil.TempDir("", "cliguana_github_ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(bundle, pemBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.CABundles = []string{bundle}
+
+	login, err := ValidateToken(context.Background(), cfg, "github_token")
+	if err != nil || login != "octocat" {
+		t.Errorf("Expected login octocat with the CA bundle trusted, got '%s', %v", login, err)
+	}
+	if _, err := ValidateToken(context.Background(), cfg, "wrong_token"); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+}
+
+// Test that only a 401 rejects a token, and that other refusals are explained
+func TestValidateToken_Forbidden(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer limited_token":
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", "1700000000")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message": "API rate limit exceeded"}`))
+		case "Bearer sso_token":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message": "Resource protected by organization SAML enforcement."}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	defer func(url string) { APIURL = url }(APIURL)
+	APIURL = server.URL
+
+	cfg := config.DefaultConfig()
+	tests := map[string]string{
+		"limited_token": "rate limit exceeded",
+		"sso_token":     "SAML enforcement",
+	}
+	for token, detail := range tests {
+		_, err := ValidateToken(context.Background(), cfg, token)
+		if err == nil || err == ErrInvalidToken || !strings.Contains(err.Error(), detail) {
+			t.Errorf("Expected %s to be unvalidated with %q, got %v", token, detail, err)
+		}
+	}
+	if _, err := ValidateToken(context.Background(), cfg, "wrong_token"); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package greptile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Environment variables naming a cassette file to record API traffic to,
+// or to replay it from instead of calling the API
+const (
+	RecordEnv = "CLIGUANA_RECORD"
+	ReplayEnv = "CLIGUANA_REPLAY"
+)
+
+// ErrNotRecorded is returned when replaying a request the cassette has no
+// response for
+var ErrNotRecorded = errors.New("no recorded response in cassette")
+
+// Request body fields that differ between runs and are ignored when
+// matching requests to recordings
+var volatileFields = map[string]bool{
+	"id":        true,
+	"sessionId": true,
+}
+
+// Placeholder written to cassettes in place of secrets
+const scrubbed = "[REDACTED]"
+
+// DefaultCassette records or replays the requests of clients created by
+// NewClient; nil sends requests to the API as usual
+var DefaultCassette *Cassette
+
+// Cassette holds recorded API exchanges. When replaying, each request is
+// answered by the first unused exchange with the same method, path, query
+// and body, so repeated requests such as progress checks replay in order.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+
+	// Replay answers requests from the interactions instead of the API
+	replay bool
+	// Interactions already replayed
+	used map[int]bool
+	mu   sync.Mutex
+}
+
+// Interaction is one recorded request and its response
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request as stored in a cassette, with secrets scrubbed
+type RecordedRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response as stored in a cassette
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// NewRecorder creates an empty cassette that records requests. Tokens sent
+// in auth headers are scrubbed wherever they appear.
+func NewRecorder() *Cassette {
+	return &Cassette{}
+}
+
+// LoadCassette reads a recorded cassette for replay
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %v", err)
+	}
+	cassette := &Cassette{replay: true, used: map[int]bool{}}
+	if err := json.Unmarshal(data, cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %v", path, err)
+	}
+	return cassette, nil
+}
+
+// Save writes the recorded interactions to a cassette file
+func (c *Cassette) Save(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %v", err)
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write cassette: %v", err)
+	}
+	return nil
+}
+
+// StartCassette sets DefaultCassette to record to the file named by
+// $CLIGUANA_RECORD or replay the one named by $CLIGUANA_REPLAY. The returned
+// function saves a recording.
+func StartCassette() (func() error, error) {
+	record, replay := os.Getenv(RecordEnv), os.Getenv(ReplayEnv)
+	switch {
+	case record != "" && replay != "":
+		return nil, fmt.Errorf("set only one of %s and %s", RecordEnv, ReplayEnv)
+	case replay != "":
+		cassette, err := LoadCassette(replay)
+		if err != nil {
+			return nil, err
+		}
+		DefaultCassette = cassette
+	case record != "":
+		cassette := NewRecorder()
+		DefaultCassette = cassette
+		return func() error { return cassette.Save(record) }, nil
+	}
+	return func() error { return nil }, nil
+}
+
+// Transport records the exchanges sent through base, or answers requests
+// from the cassette without using base when replaying
+func (c *Cassette) Transport(base http.RoundTripper) http.RoundTripper {
+	return &cassetteTransport{cassette: c, base: base}
+}
+
+type cassetteTransport struct {
+	cassette *Cassette
+	base     http.RoundTripper
+}
+
+func (ct *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := ct.cassette
+
+	var reqBody []byte
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			reqBody, _ = ioutil.ReadAll(body)
+			body.Close()
+		}
+	}
+
+	if c.replay {
+		return c.play(req, reqBody)
+	}
+
+	res, err := ct.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// The interaction is added now to keep the order of requests, and its
+	// body filled in once the caller is done reading it
+	secrets := headerSecrets(req.Header)
+	c.mu.Lock()
+	index := len(c.Interactions)
+	c.Interactions = append(c.Interactions, Interaction{
+		Request: RecordedRequest{
+			Method:  req.Method,
+			URL:     scrub(req.URL.String(), secrets),
+			Headers: scrubHeaders(req.Header),
+			Body:    scrub(string(reqBody), secrets),
+		},
+		Response: RecordedResponse{
+			StatusCode: res.StatusCode,
+			Headers:    scrubHeaders(res.Header),
+		},
+	})
+	c.mu.Unlock()
+
+	res.Body = &recordingBody{body: res.Body, done: func(body []byte) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.Interactions[index].Response.Body = scrub(string(body), secrets)
+	}}
+	return res, nil
+}
+
+// recordingBody copies a response body into the cassette as the caller reads
+// it, so streamed responses still arrive chunk by chunk while recording
+type recordingBody struct {
+	body io.ReadCloser
+	read bytes.Buffer
+	// Called with everything read once the body ends or is closed
+	done func(body []byte)
+	once sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.read.Write(p[:n])
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.finish()
+	return b.body.Close()
+}
+
+func (b *recordingBody) finish() {
+	b.once.Do(func() { b.done(b.read.Bytes()) })
+}
+
+// Helper to answer a request from the first unused matching interaction
+func (c *Cassette) play(req *http.Request, body []byte) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := matchKey(req.Method, req.URL.String(), string(body))
+	for i, interaction := range c.Interactions {
+		recorded := interaction.Request
+		if c.used[i] || matchKey(recorded.Method, recorded.URL, recorded.Body) != key {
+			continue
+		}
+		c.used[i] = true
+
+		recordedRes := interaction.Response
+		header := recordedRes.Headers.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", recordedRes.StatusCode, http.StatusText(recordedRes.StatusCode)),
+			StatusCode:    recordedRes.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(strings.NewReader(recordedRes.Body)),
+			ContentLength: int64(len(recordedRes.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w for %s %s", ErrNotRecorded, req.Method, req.URL.Path)
+}
+
+// Helper to identify a request by its method, path, query and body, so
+// recordings replay against any API host. JSON bodies are compared without
+// their volatile fields and regardless of formatting.
+func matchKey(method string, rawURL string, body string) string {
+	path := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		path = u.Path
+		if u.RawQuery != "" {
+			path += "?" + u.RawQuery
+		}
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(body), &decoded); err == nil {
+		if normalized, err := json.Marshal(dropVolatile(decoded)); err == nil {
+			body = string(normalized)
+		}
+	}
+	return method + " " + path + "\n" + body
+}
+
+// Helper to remove volatile fields from decoded JSON at any depth
+func dropVolatile(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, field := range v {
+			if volatileFields[name] {
+				delete(v, name)
+				continue
+			}
+			v[name] = dropVolatile(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = dropVolatile(item)
+		}
+	}
+	return value
+}
+
+// Helper to collect the tokens sent in a request's secret headers
+func headerSecrets(header http.Header) []string {
+	var secrets []string
+	for name, values := range header {
+		if !redactedHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		for _, value := range values {
+			if _, token, ok := strings.Cut(value, " "); ok {
+				value = token
+			}
+			if value != "" {
+				secrets = append(secrets, value)
+			}
+		}
+	}
+	return secrets
+}
+
+// Helper to replace secrets wherever they appear in a recorded value
+func scrub(value string, secrets []string) string {
+	for _, secret := range secrets {
+		value = strings.ReplaceAll(value, secret, scrubbed)
+	}
+	return value
+}
+
+// Helper to copy headers with the values of secret headers replaced
+func scrubHeaders(header http.Header) http.Header {
+	scrubbedHeader := http.Header{}
+	for name, values := range header {
+		for _, value := range values {
+			if redactedHeaders[http.CanonicalHeaderKey(name)] {
+				value = redactValue(value)
+			}
+			scrubbedHeader.Add(name, value)
+		}
+	}
+	return scrubbedHeader
+}
@@ -0,0 +1,58 @@
+package greptile
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+)
+
+// Conversation is a multi-turn query session. Every question asked through
+// it is sent with the earlier turns under the same session ID, so follow-up
+// questions can refer to earlier answers.
+type Conversation struct {
+	SessionID string    `json:"sessionId"`
+	Messages  []Message `json:"messages"`
+}
+
+// NewConversation starts a conversation with a new session ID
+func NewConversation() *Conversation {
+	return &Conversation{SessionID: NewID(), Messages: []Message{}}
+}
+
+// Ask sends a question about a repository with the conversation so far and
+// records the question and its answer as two new turns. With onText set the
+// answer is streamed to it as it arrives. A failed question is not recorded.
+func (c *Client) Ask(ctx context.Context, conv *Conversation, repository string, remote string, branch string, question string, onText func(string) error) (QueryResponse, error) {
+	if conv.SessionID == "" {
+		conv.SessionID = NewID()
+	}
+	opts := QueryOptions{SessionID: conv.SessionID, History: conv.Messages, MessageID: NewID()}
+
+	var answer QueryResponse
+	var err error
+	if onText != nil {
+		answer, err = c.StreamQueryRepoRequest(ctx, repository, remote, branch, question, opts, onText)
+	} else {
+		answer, err = c.SendQueryRepoRequest(ctx, repository, remote, branch, question, opts)
+	}
+	if err != nil {
+		return answer, err
+	}
+
+	conv.Messages = append(conv.Messages,
+		Message{ID: opts.MessageID, Content: question, Role: RoleUser},
+		Message{ID: NewID(), Content: answer.Message, Role: RoleAssistant},
+	)
+	return answer, nil
+}
+
+// NewID returns a random version 4 UUID for messages and sessions
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("failed to generate ID: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
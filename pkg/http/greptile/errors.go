@@ -0,0 +1,183 @@
+package greptile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrorKind classifies why an API request failed
+type ErrorKind string
+
+const (
+	KindAuth       ErrorKind = "auth"
+	KindNotFound   ErrorKind = "not-found"
+	KindRateLimit  ErrorKind = "rate-limit"
+	KindServer     ErrorKind = "server"
+	KindNetwork    ErrorKind = "network"
+	KindTimeout    ErrorKind = "timeout"
+	KindBadRequest ErrorKind = "bad-request"
+)
+
+// Headers that may carry the ID of a request for support
+var requestIDHeaders = []string{"X-Request-Id", "Request-Id", "X-Amzn-Requestid", "X-Amz-Cf-Id"}
+
+// APIError describes a failed Greptile API request
+type APIError struct {
+	Kind ErrorKind
+	// What the request was trying to do, e.g. "query repository"
+	Op string
+	// HTTP status, 0 when no response was received
+	StatusCode int
+	Status     string
+	// Error code and message reported by the API, if any
+	Code      string
+	Message   string
+	RequestID string
+	// Underlying error for network failures
+	Err error
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "failed to %s", e.Op)
+	if e.Status != "" {
+		fmt.Fprintf(&b, ", status: %s", e.Status)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	}
+	if e.Code != "" {
+		fmt.Fprintf(&b, " (code: %s)", e.Code)
+	}
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " (request ID: %s)", e.RequestID)
+	}
+	return b.String()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Is lets errors.Is match auth failures against ErrInvalidToken
+func (e *APIError) Is(target error) bool {
+	return target == ErrInvalidToken && e.Kind == KindAuth
+}
+
+// timeoutLimit is the cause of a context cancelled by an operation's
+// configured timeout, naming the key that sets it
+type timeoutLimit struct {
+	timeout time.Duration
+	key     string
+}
+
+func (l *timeoutLimit) Error() string {
+	return fmt.Sprintf("timed out after %s waiting for the Greptile API; raise the limit with --timeout or the %s config key", l.timeout, l.key)
+}
+
+// Helper to describe a request that got no response, telling timeouts
+// apart from other network failures
+func networkError(ctx context.Context, op string, err error) *APIError {
+	apiErr := &APIError{
+		Kind:    KindNetwork,
+		Op:      op,
+		Message: err.Error(),
+		Err:     err,
+	}
+
+	var limit *timeoutLimit
+	var netErr net.Error
+	switch {
+	case errors.As(context.Cause(ctx), &limit):
+		apiErr.Kind = KindTimeout
+		apiErr.Message = limit.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		apiErr.Kind = KindTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		// The transport only limits connecting and the TLS handshake
+		apiErr.Kind = KindTimeout
+		apiErr.Message = fmt.Sprintf("timed out connecting to the Greptile API; raise the ConnectTimeout config key: %v", err)
+	}
+	return apiErr
+}
+
+// Helper to describe an unsuccessful response, reading the error code,
+// message and request ID from the body and headers when the API sent them
+func responseError(op string, res *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		Kind:       kindOf(res.StatusCode),
+		Op:         op,
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+	}
+	for _, header := range requestIDHeaders {
+		if id := res.Header.Get(header); id != "" {
+			apiErr.RequestID = id
+			break
+		}
+	}
+
+	// The API reports errors as {"error": ...} or {"message": ...}, where
+	// error is either the message or an object holding it
+	var decoded struct {
+		Error     json.RawMessage `json:"error"`
+		Message   string          `json:"message"`
+		Code      interface{}     `json:"code"`
+		RequestID string          `json:"requestId"`
+	}
+	if err := json.Unmarshal(body, &decoded); err == nil {
+		apiErr.Message = decoded.Message
+		if decoded.Code != nil {
+			apiErr.Code = fmt.Sprint(decoded.Code)
+		}
+		if decoded.RequestID != "" && apiErr.RequestID == "" {
+			apiErr.RequestID = decoded.RequestID
+		}
+
+		var message string
+		var nested struct {
+			Message string      `json:"message"`
+			Code    interface{} `json:"code"`
+		}
+		if json.Unmarshal(decoded.Error, &message) == nil && message != "" {
+			apiErr.Message = message
+		} else if json.Unmarshal(decoded.Error, &nested) == nil && nested.Message != "" {
+			apiErr.Message = nested.Message
+			if nested.Code != nil {
+				apiErr.Code = fmt.Sprint(nested.Code)
+			}
+		}
+	}
+
+	// Fall back to the start of the body, e.g. an HTML error page
+	if apiErr.Message == "" {
+		text := strings.TrimSpace(string(body))
+		if len(text) > 200 {
+			text = text[:200] + "..."
+		}
+		apiErr.Message = text
+	}
+	return apiErr
+}
+
+// Helper to classify a failed response by its status code
+func kindOf(statusCode int) ErrorKind {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return KindAuth
+	case statusCode == http.StatusNotFound:
+		return KindNotFound
+	case statusCode == http.StatusTooManyRequests:
+		return KindRateLimit
+	case statusCode >= 500:
+		return KindServer
+	default:
+		return KindBadRequest
+	}
+}
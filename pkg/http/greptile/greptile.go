@@ -2,11 +2,16 @@ package greptile
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"cliguana/config"
@@ -35,90 +40,329 @@ type RepositoryInfo struct {
 	Sha             string   `json:"sha"`
 }
 
-// Custom HTTP client with a timeout
-var httpClient = &http.Client{
-	Timeout: 30 * time.Second,
+// DefaultUserAgent identifies the CLI to the API
+const DefaultUserAgent = "cliguana"
+
+// ErrMissingToken is returned when no Greptile auth token is configured
+var ErrMissingToken = errors.New("no Greptile auth token configured; run `cliguana login` or set GREPTILE_AUTH_TOKEN")
+
+// ErrListUnsupported is returned when the API doesn't list repositories
+var ErrListUnsupported = errors.New("the Greptile API doesn't list repositories")
+
+// ErrInvalidToken matches the errors returned when the Greptile API rejects
+// the auth token
+var ErrInvalidToken = errors.New("token was rejected by Greptile")
+
+// Header carrying the source control token for each remote type
+var remoteTokenHeaders = map[string]string{
+	"github": "X-GitHub-Token",
+	"gitlab": "X-GitLab-Token",
+	"azure":  "X-Azure-Token",
 }
 
-func SendIndexRequest(cfg *config.Config, repository string, remoteType string, branch string) error {
-	uploadRequest := UploadRequest{
-		Remote:     remoteType,
-		Repository: repository,
-		Branch:     branch,
-		Reload:     false,
-		Notify:     true,
+// Client sends requests to the Greptile API
+type Client struct {
+	// APIRoot is the versioned API root, e.g. https://api.greptile.com/v2
+	APIRoot    string
+	HTTPClient *http.Client
+	UserAgent  string
+	// Headers are added to every request
+	Headers http.Header
+	Retry   RetryPolicy
+	// Log receives a line for every retry; nil disables logging
+	Log io.Writer
+
+	cfg *config.Config
+	// Error from setting up the transport, returned by every request
+	err error
+	// Requests are answered from a cassette, so no real token is needed
+	replay bool
+}
+
+// NewClient creates a client for the API root, tokens, retry budget,
+// timeouts and network settings in the config
+func NewClient(cfg *config.Config) *Client {
+	retry := DefaultRetryPolicy
+	retry.MaxAttempts = cfg.RetryMaxAttempts
+	retry.MaxElapsed = time.Duration(cfg.RetryMaxElapsed)
+
+	// A bad proxy or TLS setting fails every request instead of being ignored
+	var transport http.RoundTripper = http.DefaultTransport
+	base, err := NewTransport(cfg)
+	if err == nil {
+		transport = base
+	}
+	replay := false
+	if DefaultCassette != nil {
+		// Replayed requests never reach the network
+		if DefaultCassette.replay {
+			err = nil
+			replay = true
+		}
+		transport = DefaultCassette.Transport(transport)
+	}
+	if DefaultTracer != nil {
+		transport = DefaultTracer.Transport(transport)
 	}
 
-	payloadBytes, err := json.Marshal(uploadRequest)
-	if err != nil {
-		return fmt.Errorf("failed to marshal upload request: %v", err)
+	return &Client{
+		APIRoot:    strings.TrimSuffix(cfg.BaseURL, "/"),
+		HTTPClient: &http.Client{Transport: transport},
+		UserAgent:  DefaultUserAgent,
+		Headers:    http.Header{},
+		Retry:      retry,
+		Log:        os.Stderr,
+		cfg:        cfg,
+		err:        err,
+		replay:     replay,
 	}
+}
 
-	req, err := http.NewRequest("POST", cfg.BaseURL, bytes.NewBuffer(payloadBytes))
+// Helper to limit an operation to its configured timeout
+func (c *Client) withTimeout(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	timeout := c.cfg.TimeoutFor(op)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, timeout, &timeoutLimit{timeout: timeout, key: config.TimeoutKey(op)})
+}
+
+// Helper to build a request for an API path, JSON encoding the payload if any
+func (c *Client) newRequest(ctx context.Context, method string, path string, payload interface{}) (*http.Request, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewBuffer(payloadBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.APIRoot+path, body)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
-	req.Header.Add("Authorization", "Bearer "+cfg.AuthToken)
-	req.Header.Add("X-GitHub-Token", cfg.GithubToken)
-	req.Header.Add("Content-Type", "application/json")
+	for name, values := range c.Headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
 
-	res, err := httpClient.Do(req)
+// Helper to add the auth headers shared by every request, including the
+// source control token matching the repository's remote. When replaying a
+// cassette a missing token is replaced by the placeholder cassettes record,
+// so recordings replay without an account.
+func (c *Client) addAuthHeaders(req *http.Request, remote string) error {
+	token := c.cfg.AuthToken
+	if token == "" && c.replay {
+		token = scrubbed
+	}
+	if token == "" {
+		return ErrMissingToken
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	// Only the header of the remote's own provider carries its token
+	header, ok := remoteTokenHeaders[c.cfg.RemoteType(remote)]
+	if !ok {
+		return nil
+	}
+	if token := c.cfg.TokenForRemote(remote); token != "" {
+		req.Header.Set(header, token)
+	}
+	return nil
+}
+
+// Helper to send a request, retrying it within the client's retry budget,
+// and read the whole response body
+func (c *Client) do(op string, req *http.Request, idempotent bool) (*http.Response, []byte, error) {
+	res, err := c.open(op, req, idempotent)
 	if err != nil {
-		return fmt.Errorf("failed to make the request: %v", err)
+		return nil, nil, err
 	}
 	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
+		return nil, nil, networkError(req.Context(), op, err)
+	}
+	return res, body, nil
+}
+
+// Helper to send a request, retrying it within the client's retry budget,
+// and return the response with its body still open. Requests that are not
+// idempotent are only retried when the server refused them without
+// handling them.
+func (c *Client) open(op string, req *http.Request, idempotent bool) (*http.Response, error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		res, err := c.HTTPClient.Do(req)
+		if err != nil {
+			err = networkError(req.Context(), op, err)
+		}
+		if attempt >= c.Retry.MaxAttempts || !retryable(res, err, idempotent) {
+			return res, err
+		}
+
+		delay, ok := retryAfter(res)
+		if !ok {
+			delay = c.Retry.backoff(attempt)
+		}
+		if c.Retry.MaxElapsed > 0 && time.Since(start)+delay > c.Retry.MaxElapsed {
+			return res, err
+		}
+		if res != nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+		c.logRetry(req, describeFailure(res, err), delay, attempt+1)
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, networkError(req.Context(), op, req.Context().Err())
+		}
+
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %v", err)
+			}
+		}
+	}
+}
+
+// Ping checks that the Greptile API answers at all, without authenticating
+func (c *Client) Ping(ctx context.Context) error {
+	ctx, cancel := c.withTimeout(ctx, "")
+	defer cancel()
+
+	req, err := c.newRequest(ctx, "GET", "/repositories", nil)
+	if err != nil {
+		return err
+	}
+
+	res, body, err := c.do("reach the Greptile API", req, true)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode >= 500 {
+		return responseError("reach the Greptile API", res, body)
+	}
+	return nil
+}
+
+// ValidateToken checks the auth token by listing repositories on the Greptile API
+func (c *Client) ValidateToken(ctx context.Context) error {
+	ctx, cancel := c.withTimeout(ctx, "")
+	defer cancel()
+
+	req, err := c.newRequest(ctx, "GET", "/repositories", nil)
+	if err != nil {
+		return err
+	}
+
+	if err := c.addAuthHeaders(req, ""); err != nil {
+		return err
+	}
+
+	res, body, err := c.do("validate token", req, true)
+	if err != nil {
+		return err
+	}
+
+	// Only a successful listing proves the token was accepted. Auth failures
+	// match ErrInvalidToken; any other answer, such as a 404 from an API
+	// without this endpoint, leaves the token unvalidated.
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return responseError("validate token", res, body)
+	}
+	return nil
+}
+
+// NewUploadRequest builds the request to index a branch of a repository.
+// With reload set a branch that is already indexed is indexed again from
+// scratch; with notify set an email is sent when indexing finishes.
+func (c *Client) NewUploadRequest(repository string, remote string, branch string, reload bool, notify bool) UploadRequest {
+	return UploadRequest{
+		Remote:     c.cfg.RemoteType(remote),
+		Repository: repository,
+		Branch:     branch,
+		Reload:     reload,
+		Notify:     notify,
+	}
+}
+
+// SendIndexRequest asks the Greptile API to index a repository, sending the
+// source control token for its remote
+func (c *Client) SendIndexRequest(ctx context.Context, remote string, uploadRequest UploadRequest) error {
+	ctx, cancel := c.withTimeout(ctx, config.OpIndex)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, "POST", "/repositories", uploadRequest)
+	if err != nil {
+		return err
+	}
+
+	if err := c.addAuthHeaders(req, remote); err != nil {
+		return err
+	}
+
+	// Indexing the same branch again is a no-op for the API, unless it is
+	// reloaded: repeating that restarts indexing and sends another email
+	res, body, err := c.do("trigger upload", req, !uploadRequest.Reload)
+	if err != nil {
+		return err
 	}
 
 	if res.StatusCode >= 200 && res.StatusCode < 300 {
 		fmt.Println("Greptile indexing triggered successfully!")
 	} else {
-		return fmt.Errorf("failed to trigger upload, status: %s, response: %s", res.Status, string(body))
+		return responseError("trigger upload", res, body)
 	}
 
 	return nil
 }
 
 // SendGetInfoRequest sends a request to get repository information from the Greptile API
-func SendGetInfoRequest(cfg *config.Config, repository string, remote string, branch string) (RepositoryInfo, error) {
+func (c *Client) SendGetInfoRequest(ctx context.Context, repository string, remote string, branch string) (RepositoryInfo, error) {
+	ctx, cancel := c.withTimeout(ctx, config.OpInfo)
+	defer cancel()
+
 	var repoInfo RepositoryInfo
 
-	// Extract the repository name from the remote URL
-	repoName := util.ExtractRepoName(remote)
-	if repoName == "" {
-		return repoInfo, fmt.Errorf("invalid remote URL: %s", remote)
+	repositoryId, err := c.repositoryID(remote, branch)
+	if err != nil {
+		return repoInfo, err
 	}
 
-	// Format the repositoryId as remote:branch:owner/repository
-	repositoryId := fmt.Sprintf("%s:%s:%s", util.GetRemoteType(remote), branch, repoName)
-
 	// URL-encode the repositoryId
-	url := fmt.Sprintf("%s/%s", cfg.BaseURL, url.PathEscape(repositoryId))
-
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := c.newRequest(ctx, "GET", "/repositories/"+url.PathEscape(repositoryId), nil)
 	if err != nil {
-		return repoInfo, fmt.Errorf("failed to create request: %v", err)
+		return repoInfo, err
 	}
 
-	req.Header.Add("Authorization", "Bearer "+cfg.AuthToken)
-
-	res, err := httpClient.Do(req)
-	if err != nil {
-		return repoInfo, fmt.Errorf("failed to send request: %v", err)
+	if err := c.addAuthHeaders(req, remote); err != nil {
+		return repoInfo, err
 	}
-	defer res.Body.Close()
 
-	body, err := ioutil.ReadAll(res.Body)
+	res, body, err := c.do("get repository info", req, true)
 	if err != nil {
-		return repoInfo, fmt.Errorf("failed to read response: %v", err)
+		return repoInfo, err
 	}
 
 	if res.StatusCode != 200 {
-		return repoInfo, fmt.Errorf("received non-200 response: %s, response: %s", res.Status, string(body))
+		return repoInfo, responseError("get repository info", res, body)
 	}
 
 	err = json.Unmarshal(body, &repoInfo)
@@ -129,69 +373,230 @@ func SendGetInfoRequest(cfg *config.Config, repository string, remote string, br
 	return repoInfo, nil
 }
 
-// SendQueryRepoRequest sends a semantic query request to the Greptile API
-func SendQueryRepoRequest(cfg *config.Config, repository string, remote string, branch string, query string) (string, error) {
-	url := "https://api.greptile.com/v2/query" // Corrected endpoint URL
+// ListRepositories fetches every repository indexed for the account. It
+// returns ErrListUnsupported when the API has no listing endpoint.
+func (c *Client) ListRepositories(ctx context.Context) ([]RepositoryInfo, error) {
+	ctx, cancel := c.withTimeout(ctx, config.OpInfo)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, "GET", "/repositories", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.addAuthHeaders(req, ""); err != nil {
+		return nil, err
+	}
+
+	res, body, err := c.do("list repositories", req, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusMethodNotAllowed {
+		return nil, ErrListUnsupported
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, responseError("list repositories", res, body)
+	}
+
+	// The list is either the whole body or wrapped in an object
+	var repos []RepositoryInfo
+	if err := json.Unmarshal(body, &repos); err == nil {
+		return repos, nil
+	}
+	var wrapped struct {
+		Repositories *[]RepositoryInfo `json:"repositories"`
+	}
+	if err := json.Unmarshal(body, &wrapped); err == nil && wrapped.Repositories != nil {
+		return *wrapped.Repositories, nil
+	}
+	return nil, ErrListUnsupported
+}
+
+// SendDeleteRequest asks the Greptile API to delete the index of one branch
+// of a repository. A repository that isn't indexed is a not-found error.
+func (c *Client) SendDeleteRequest(ctx context.Context, repository string, remote string, branch string) error {
+	ctx, cancel := c.withTimeout(ctx, config.OpIndex)
+	defer cancel()
+
+	repositoryId, err := c.repositoryID(remote, branch)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, "DELETE", "/repositories/"+url.PathEscape(repositoryId), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := c.addAuthHeaders(req, remote); err != nil {
+		return err
+	}
+
+	// A delete whose answer was lost would be retried into a 404, reporting
+	// an index that was deleted as not indexed, so it is only sent again when
+	// the server refused it
+	res, body, err := c.do("delete repository index", req, false)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return responseError("delete repository index", res, body)
+	}
+	return nil
+}
+
+// Helper to format the ID the API gives a branch of a repository, as
+// remote:branch:owner/repository
+func (c *Client) repositoryID(remote string, branch string) (string, error) {
+	// Extract the repository name from the remote URL
+	repoName := util.ExtractRepoName(remote)
+	if repoName == "" {
+		return "", fmt.Errorf("invalid remote URL: %s", remote)
+	}
+	return fmt.Sprintf("%s:%s:%s", c.cfg.RemoteType(remote), branch, repoName), nil
+}
+
+// QueryOptions carry the conversation a query belongs to
+type QueryOptions struct {
+	// Session the query belongs to; a new ID is generated when empty
+	SessionID string
+	// Earlier turns of the conversation, oldest first
+	History []Message
+	// ID of the question; a new ID is generated when empty
+	MessageID string
+}
+
+// Helper to build a semantic query request, sending the earlier turns of the
+// conversation before the question. The configured Scope is added to the
+// outgoing question only, so it isn't repeated in later turns.
+func (c *Client) queryRequest(ctx context.Context, repository string, remote string, branch string, query string, opts QueryOptions, stream bool) (*http.Request, error) {
+	sessionID := opts.SessionID
+	if sessionID == "" {
+		sessionID = NewID()
+	}
+	messageID := opts.MessageID
+	if messageID == "" {
+		messageID = NewID()
+	}
+	if len(c.cfg.Scope) > 0 {
+		query = fmt.Sprintf("%s\n\nFocus on these paths: %s", query, strings.Join(c.cfg.Scope, ", "))
+	}
+	messages := append([]Message{}, opts.History...)
+	messages = append(messages, Message{ID: messageID, Content: query, Role: RoleUser})
+
 	payload := map[string]interface{}{
-		"messages": []map[string]string{
-			{
-				"id":      "<string>",
-				"content": query,
-				"role":    "user",
-			},
-		},
+		"messages": messages,
 		"repositories": []map[string]string{
 			{
-				"remote":     util.GetRemoteType(remote),
+				"remote":     c.cfg.RemoteType(remote),
 				"branch":     branch,
 				"repository": repository,
 			},
 		},
-		"sessionId": "<session-id>", // Replace with actual session ID if needed
-		"stream":    true,
-		"genius":    true,
+		"sessionId": sessionID,
+		"stream":    stream,
+		"genius":    c.cfg.Genius,
 	}
 
-	payloadBytes, err := json.Marshal(payload)
+	req, err := c.newRequest(ctx, "POST", "/query", payload)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal query request: %v", err)
+		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+	if err := c.addAuthHeaders(req, remote); err != nil {
+		return nil, err
 	}
+	return req, nil
+}
 
-	req.Header.Add("Authorization", "Bearer "+cfg.AuthToken)
-	req.Header.Add("X-GitHub-Token", cfg.GithubToken)
-	req.Header.Add("Content-Type", "application/json")
+// SendQueryRepoRequest sends a semantic query request to the Greptile API and
+// waits for the complete answer. Genius mode follows the Genius config key.
+func (c *Client) SendQueryRepoRequest(ctx context.Context, repository string, remote string, branch string, query string, opts QueryOptions) (QueryResponse, error) {
+	ctx, cancel := c.withTimeout(ctx, config.OpQuery)
+	defer cancel()
 
-	res, err := httpClient.Do(req)
+	var answer QueryResponse
+
+	req, err := c.queryRequest(ctx, repository, remote, branch, query, opts, false)
 	if err != nil {
-		return "", fmt.Errorf("failed to make the request: %v", err)
+		return answer, err
 	}
-	defer res.Body.Close()
 
-	body, err := ioutil.ReadAll(res.Body)
+	// A query may be answered even if the response is lost, so only retry refusals
+	res, body, err := c.do("query repository", req, false)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
+		return answer, err
 	}
 
-	if res.StatusCode >= 200 && res.StatusCode < 300 {
-		return string(body), nil
-	} else {
-		return "", fmt.Errorf("failed to query repository, status: %s, response: %s", res.Status, string(body))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return answer, responseError("query repository", res, body)
 	}
+
+	err = json.Unmarshal(body, &answer)
+	if err != nil {
+		return answer, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+
+	return answer, nil
 }
 
-// SendSearchRepoRequest sends a search query request to the Greptile API
-func SendSearchRepoRequest(cfg *config.Config, repository string, remote string, branch string, query string) (string, error) {
-	url := "https://api.greptile.com/v2/search"
+// StreamQueryRepoRequest sends a semantic query request to the Greptile API,
+// calls onText with every part of the answer as it arrives and returns the
+// complete answer
+func (c *Client) StreamQueryRepoRequest(ctx context.Context, repository string, remote string, branch string, query string, opts QueryOptions, onText func(string) error) (QueryResponse, error) {
+	ctx, cancel := c.withTimeout(ctx, config.OpQuery)
+	defer cancel()
+
+	var answer QueryResponse
+
+	req, err := c.queryRequest(ctx, repository, remote, branch, query, opts, true)
+	if err != nil {
+		return answer, err
+	}
+
+	// A query may be answered even if the response is lost, so only retry refusals
+	res, err := c.open("query repository", req, false)
+	if err != nil {
+		return answer, err
+	}
+	defer res.Body.Close()
 
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return answer, responseError("query repository", res, body)
+	}
+
+	var message strings.Builder
+	err = readStream(ctx, res.Body, func(c chunk) error {
+		switch c.Type {
+		case chunkMessage:
+			text := c.text()
+			message.WriteString(text)
+			if text != "" {
+				return onText(text)
+			}
+		case chunkSources:
+			var sources []Source
+			if err := json.Unmarshal(c.Message, &sources); err != nil {
+				return fmt.Errorf("failed to unmarshal sources: %v", err)
+			}
+			answer.Sources = append(answer.Sources, sources...)
+		}
+		return nil
+	})
+	answer.Message = message.String()
+	return answer, err
+}
+
+// Helper to build a search request
+func (c *Client) searchRequest(ctx context.Context, repository string, remote string, branch string, query string, stream bool) (*http.Request, error) {
 	// Identify the remote type
-	remoteType := util.GetRemoteType(remote)
+	remoteType := c.cfg.RemoteType(remote)
 	if remoteType == "" {
-		return "", fmt.Errorf("invalid remote URL: %s", remote)
+		return nil, fmt.Errorf("invalid remote URL: %s", remote)
 	}
 
 	payload := map[string]interface{}{
@@ -204,38 +609,86 @@ func SendSearchRepoRequest(cfg *config.Config, repository string, remote string,
 				"type":       remoteType, // Include the remote type
 			},
 		},
-		"sessionId": "<session-id>", // Replace with actual session ID if needed
-		"stream":    true,
+		"sessionId": NewID(),
+		"stream":    stream,
 	}
 
-	payloadBytes, err := json.Marshal(payload)
+	req, err := c.newRequest(ctx, "POST", "/search", payload)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal search request: %v", err)
+		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
+	if err := c.addAuthHeaders(req, remote); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// SendSearchRepoRequest sends a search query request to the Greptile API and
+// waits for the complete results
+func (c *Client) SendSearchRepoRequest(ctx context.Context, repository string, remote string, branch string, query string) ([]SearchResult, error) {
+	ctx, cancel := c.withTimeout(ctx, config.OpSearch)
+	defer cancel()
+
+	var results []SearchResult
+
+	req, err := c.searchRequest(ctx, repository, remote, branch, query, false)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return results, err
 	}
 
-	req.Header.Add("Authorization", "Bearer "+cfg.AuthToken)
-	req.Header.Add("X-GitHub-Token", cfg.GithubToken)
-	req.Header.Add("Content-Type", "application/json")
+	res, body, err := c.do("search repository", req, true)
+	if err != nil {
+		return results, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return results, responseError("search repository", res, body)
+	}
 
-	res, err := httpClient.Do(req)
+	err = json.Unmarshal(body, &results)
 	if err != nil {
-		return "", fmt.Errorf("failed to make the request: %v", err)
+		return results, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
-	defer res.Body.Close()
 
-	body, err := ioutil.ReadAll(res.Body)
+	return results, nil
+}
+
+// StreamSearchRepoRequest sends a search query request to the Greptile API,
+// calls onResults with every batch of results as it arrives and returns all
+// of them
+func (c *Client) StreamSearchRepoRequest(ctx context.Context, repository string, remote string, branch string, query string, onResults func([]SearchResult) error) ([]SearchResult, error) {
+	ctx, cancel := c.withTimeout(ctx, config.OpSearch)
+	defer cancel()
+
+	var results []SearchResult
+
+	req, err := c.searchRequest(ctx, repository, remote, branch, query, true)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
+		return results, err
 	}
 
-	if res.StatusCode >= 200 && res.StatusCode < 300 {
-		return string(body), nil
-	} else {
-		return "", fmt.Errorf("failed to search repository, status: %s, response: %s", res.Status, string(body))
+	res, err := c.open("search repository", req, true)
+	if err != nil {
+		return results, err
 	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return results, responseError("search repository", res, body)
+	}
+
+	err = readStream(ctx, res.Body, func(c chunk) error {
+		if c.Type != chunkSources {
+			return nil
+		}
+		var batch []SearchResult
+		if err := json.Unmarshal(c.Message, &batch); err != nil {
+			return fmt.Errorf("failed to unmarshal results: %v", err)
+		}
+		results = append(results, batch...)
+		return onResults(batch)
+	})
+	return results, err
 }
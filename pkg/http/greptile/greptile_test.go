@@ -0,0 +1,738 @@
+package greptile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"cliguana/config"
+)
+
+// Test that every request goes to the configured API root with the client's headers
+func TestClient_Endpoints(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.EscapedPath())
+		if r.Header.Get("Authorization") != "Bearer test_token" {
+			t.Errorf("Expected the auth token on %s, got '%s'", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("User-Agent") != "cliguana-test" || r.Header.Get("X-Test") != "yes" {
+			t.Errorf("Expected the client's headers on %s, got %v", r.URL.Path, r.Header)
+		}
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(RepositoryInfo{Repository: "owner/repo", Status: "completed"})
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/query":
+			w.Write([]byte(`{"message": "answer", "sources": [{"repository": "owner/repo", "filepath": "main.go", "linestart": 3, "lineend": 9}]}`))
+		case "/v2/search":
+			w.Write([]byte(`[{"repository": "owner/repo", "filepath": "util.go", "linestart": 1, "lineend": 2, "summary": "helpers", "distance": 0.25}]`))
+		default:
+			w.Write([]byte(`ok`))
+		}
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL + "/v2/"
+	cfg.AuthToken = "test_token"
+
+	client := NewClient(cfg)
+	client.HTTPClient = server.Client()
+	client.UserAgent = "cliguana-test"
+	client.Headers.Set("X-Test", "yes")
+
+	remote := "https://github.com/owner/repo.git"
+	if err := client.SendIndexRequest(context.Background(), remote, client.NewUploadRequest("owner/repo", remote, "main", false, true)); err != nil {
+		t.Fatalf("Failed to send index request: %v", err)
+	}
+	info, err := client.SendGetInfoRequest(context.Background(), "owner/repo", remote, "main")
+	if err != nil {
+		t.Fatalf("Failed to get info: %v", err)
+	}
+	if info.Status != "completed" {
+		t.Errorf("Expected status 'completed', got '%s'", info.Status)
+	}
+	answer, err := client.SendQueryRepoRequest(context.Background(), "owner/repo", remote, "main", "question", QueryOptions{})
+	if err != nil {
+		t.Fatalf("Failed to send query: %v", err)
+	}
+	if answer.Message != "answer" || len(answer.Sources) != 1 || answer.Sources[0].Location() != "main.go:3-9" {
+		t.Errorf("Expected the answer with one source at main.go:3-9, got %+v", answer)
+	}
+	results, err := client.SendSearchRepoRequest(context.Background(), "owner/repo", remote, "main", "search")
+	if err != nil {
+		t.Fatalf("Failed to send search: %v", err)
+	}
+	if len(results) != 1 || results[0].Location() != "util.go:1-2" || results[0].Summary != "helpers" || results[0].Distance != 0.25 {
+		t.Errorf("Expected one result at util.go:1-2, got %+v", results)
+	}
+	if err := client.SendDeleteRequest(context.Background(), "owner/repo", remote, "main"); err != nil {
+		t.Fatalf("Failed to send delete request: %v", err)
+	}
+
+	expected := []string{
+		"POST /v2/repositories",
+		"GET /v2/repositories/github:main:owner%2Frepo",
+		"POST /v2/query",
+		"POST /v2/search",
+		"DELETE /v2/repositories/github:main:owner%2Frepo",
+	}
+	if len(paths) != len(expected) {
+		t.Fatalf("Expected requests %v, got %v", expected, paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("Expected request %d to be '%s', got '%s'", i, expected[i], paths[i])
+		}
+	}
+}
+
+// Test that requests fail before reaching the API without an auth token
+func TestClient_MissingToken(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = "http://127.0.0.1:0"
+
+	if _, err := NewClient(cfg).SendGetInfoRequest(context.Background(), "owner/repo", "https://github.com/owner/repo.git", "main"); err != ErrMissingToken {
+		t.Errorf("Expected ErrMissingToken, got %v", err)
+	}
+}
+
+// Test that transient failures are retried, honouring Retry-After, and that
+// queries are only retried when the server refused them
+func TestClient_Retry(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch {
+		case r.URL.Path == "/v2/query":
+			w.WriteHeader(http.StatusBadGateway)
+		case attempts == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case attempts == 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			json.NewEncoder(w).Encode(RepositoryInfo{Status: "completed"})
+		}
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL + "/v2"
+	cfg.AuthToken = "test_token"
+
+	var log bytes.Buffer
+	client := NewClient(cfg)
+	client.Retry.BaseDelay = time.Millisecond
+	client.Log = &log
+
+	remote := "https://github.com/owner/repo.git"
+	if _, err := client.SendGetInfoRequest(context.Background(), "owner/repo", remote, "main"); err != nil {
+		t.Fatalf("Expected info request to succeed after retries: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+	if strings.Count(log.String(), "retrying") != 2 {
+		t.Errorf("Expected 2 retries to be logged, got '%s'", log.String())
+	}
+
+	attempts = 0
+	if _, err := client.SendQueryRepoRequest(context.Background(), "owner/repo", remote, "main", "question", QueryOptions{}); err == nil {
+		t.Errorf("Expected query to fail")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected a failed query not to be retried, got %d attempts", attempts)
+	}
+}
+
+// Test that streamed chunks are handled as soon as they arrive
+func TestClient_StreamQuery(t *testing.T) {
+	received := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload["stream"] != true {
+			t.Errorf("Expected a streaming request, got %v", payload["stream"])
+		}
+
+		w.Write([]byte(`{"type": "status", "message": "Thinking"}` + "\n" + `{"type": "message", "message": "Hello"}` + "\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Errorf("Expected the first chunks to be handled before the response completed")
+		}
+		w.Write([]byte(`{"type": "message", "message": " world"}` + "\n"))
+		w.Write([]byte(`{"type": "sources", "message": [{"filepath": "main.go", "linestart": 1, "lineend": 5}]}`))
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "test_token"
+
+	var parts []string
+	answer, err := NewClient(cfg).StreamQueryRepoRequest(context.Background(), "owner/repo", "https://github.com/owner/repo.git", "main", "question", QueryOptions{}, func(text string) error {
+		if len(parts) == 0 {
+			close(received)
+		}
+		parts = append(parts, text)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to stream query: %v", err)
+	}
+	if strings.Join(parts, "|") != "Hello| world" {
+		t.Errorf("Expected the answer in two parts, got %q", parts)
+	}
+	if answer.Message != "Hello world" || len(answer.Sources) != 1 || answer.Sources[0].Location() != "main.go:1-5" {
+		t.Errorf("Expected the complete answer with one source, got %+v", answer)
+	}
+}
+
+// Test reading event stream framing and complete responses from servers that don't stream
+func TestReadStream(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{"data: {\"type\": \"message\", \"message\": \"a\"}\n\ndata: [DONE]\n", "message:\"a\""},
+		{": keep-alive\nretry: 3000\n\nevent: message\nid: 1\ndata: {\"type\": \"message\", \"message\": \"a\"}\n\nevent: sources\nid: 2\ndata: {\"type\": \"sources\", \"message\": []}\n\n", "message:\"a\" sources:[]"},
+		{`{"message": "answer", "sources": []}`, "message:\"answer\" sources:[]"},
+		{`[{"filepath": "main.go"}]`, "sources:[{\"filepath\": \"main.go\"}]"},
+	}
+
+	for _, test := range tests {
+		var chunks []string
+		err := readStream(context.Background(), strings.NewReader(test.body), func(chunk chunk) error {
+			chunks = append(chunks, chunk.Type+":"+string(chunk.Message))
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Failed to read '%s': %v", test.body, err)
+		}
+		if strings.Join(chunks, " ") != test.expected {
+			t.Errorf("Expected chunks '%s' for '%s', got '%s'", test.expected, test.body, strings.Join(chunks, " "))
+		}
+	}
+}
+
+// Test that failed requests are reported as classified API errors
+func TestClient_APIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Invalid token"}`))
+			return
+		}
+		w.Header().Set("X-Request-Id", "req-123")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"message": "Repository not found", "code": "REPO_NOT_FOUND"}}`))
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "test_token"
+
+	_, err := NewClient(cfg).SendGetInfoRequest(context.Background(), "owner/repo", "https://github.com/owner/repo.git", "main")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an APIError, got %v", err)
+	}
+	if apiErr.Kind != KindNotFound || apiErr.StatusCode != 404 || apiErr.Code != "REPO_NOT_FOUND" || apiErr.Message != "Repository not found" || apiErr.RequestID != "req-123" {
+		t.Errorf("Expected a not-found error with the API's code, message and request ID, got %+v", apiErr)
+	}
+
+	cfg.AuthToken = "bad_token"
+	if err := NewClient(cfg).ValidateToken(context.Background()); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+
+	cfg.BaseURL = "http://127.0.0.1:1"
+	client := NewClient(cfg)
+	client.Retry.MaxAttempts = 1
+	if err := client.Ping(context.Background()); !errors.As(err, &apiErr) || apiErr.Kind != KindNetwork {
+		t.Errorf("Expected a network error, got %v", err)
+	}
+}
+
+// Test that cancelling the context stops a request that is waiting to be retried
+func TestClient_Cancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "test_token"
+	client := NewClient(cfg)
+	client.Log = nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.SendGetInfoRequest(ctx, "owner/repo", "https://github.com/owner/repo.git", "main")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the request to be cancelled, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected cancellation to interrupt the retry wait")
+	}
+}
+
+// Test that an operation's timeout ends a slow streamed answer and is
+// reported as a timeout naming the setting, not as a network failure
+func TestClient_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"type": "message", "message": "partial"}` + "\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "test_token"
+	cfg.QueryTimeout = config.Duration(100 * time.Millisecond)
+
+	var text string
+	_, err := NewClient(cfg).StreamQueryRepoRequest(context.Background(), "owner/repo", "https://github.com/owner/repo.git", "main", "question", QueryOptions{}, func(part string) error {
+		text += part
+		return nil
+	})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != KindTimeout || !strings.Contains(err.Error(), "QueryTimeout") {
+		t.Fatalf("Expected a timeout naming QueryTimeout, got %v", err)
+	}
+	if text != "partial" {
+		t.Errorf("Expected the answer streamed before the timeout, got '%s'", text)
+	}
+
+	// A --timeout flag applies to every operation
+	if err := cfg.SetWithSource("Timeout", "2s", config.SourceFlag); err != nil {
+		t.Fatal(err)
+	}
+	if timeout := cfg.TimeoutFor(config.OpQuery); timeout != 2*time.Second {
+		t.Errorf("Expected the flag to override QueryTimeout, got %s", timeout)
+	}
+}
+
+// Test that the trace logs requests and responses and writes them as HAR
+// without the tokens
+func TestTracer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"message": "answer", "sources": []}`))
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "secret_token"
+	cfg.GithubToken = "ghp_secret"
+	cfg.GithubTokenProviders = nil
+
+	var log bytes.Buffer
+	tracer := &Tracer{Log: &log, HAR: true}
+	client := NewClient(cfg)
+	client.HTTPClient.Transport = tracer.Transport(client.HTTPClient.Transport)
+	if _, err := client.SendQueryRepoRequest(context.Background(), "owner/repo", "https://github.com/owner/repo.git", "main", "question", QueryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var har bytes.Buffer
+	if err := tracer.WriteHAR(&har); err != nil {
+		t.Fatal(err)
+	}
+	for name, trace := range map[string]string{"log": log.String(), "HAR": har.String()} {
+		if strings.Contains(trace, "secret") {
+			t.Errorf("Expected the %s to redact tokens, got:\n%s", name, trace)
+		}
+		for _, want := range []string{"/query", "Bearer [REDACTED]", "X-Github-Token", "question", "answer"} {
+			if !strings.Contains(trace, want) {
+				t.Errorf("Expected the %s to contain '%s', got:\n%s", name, want, trace)
+			}
+		}
+	}
+
+	var archive struct {
+		Log struct {
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(har.Bytes(), &archive); err != nil {
+		t.Fatalf("Expected valid HAR JSON: %v", err)
+	}
+	if len(archive.Log.Entries) != 1 || archive.Log.Entries[0].Response.Status != 200 {
+		t.Errorf("Expected one HAR entry with status 200, got %+v", archive.Log.Entries)
+	}
+}
+
+// Test that servers signed by a CA from CABundles are trusted
+func TestClient_CABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.RetryMaxAttempts = 1
+	if err := NewClient(cfg).Ping(context.Background()); err == nil {
+		t.Fatalf("Expected the test server's certificate to be untrusted")
+	}
+
+	dir, err := ioutil.TempDir("", "cliguana_ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(bundle, pemBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.CABundles = []string{bundle}
+	if err := NewClient(cfg).Ping(context.Background()); err != nil {
+		t.Errorf("Expected the CA bundle to be trusted, got %v", err)
+	}
+
+	cfg.CABundles = []string{filepath.Join(dir, "missing.pem")}
+	if err := NewClient(cfg).Ping(context.Background()); err == nil || !strings.Contains(err.Error(), "CA bundle") {
+		t.Errorf("Expected a missing CA bundle to fail requests, got %v", err)
+	}
+}
+
+// Test that a recorded cassette replays without the server, with tokens
+// scrubbed, and that unrecorded requests fail without being retried
+func TestCassette(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(RepositoryInfo{Repository: "owner/repo", Status: "completed", FilesProcessed: 5, NumFiles: 5})
+	}))
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "secret_token"
+	cfg.GithubToken = "ghp_secret"
+	cfg.GithubTokenProviders = nil
+
+	recorder := NewRecorder()
+	client := NewClient(cfg)
+	client.HTTPClient.Transport = recorder.Transport(client.HTTPClient.Transport)
+	if _, err := client.SendGetInfoRequest(context.Background(), "owner/repo", "https://github.com/owner/repo.git", "main"); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	dir, err := ioutil.TempDir("", "cliguana_cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+	if err := recorder.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(saved), "secret") {
+		t.Errorf("Expected tokens to be scrubbed from the cassette, got:\n%s", saved)
+	}
+
+	player, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = NewClient(cfg)
+	client.HTTPClient.Transport = player.Transport(client.HTTPClient.Transport)
+	info, err := client.SendGetInfoRequest(context.Background(), "owner/repo", "https://github.com/owner/repo.git", "main")
+	if err != nil || info.FilesProcessed != 5 {
+		t.Errorf("Expected the recorded info, got %+v, %v", info, err)
+	}
+
+	// Every recording is replayed once
+	start := time.Now()
+	_, err = client.SendGetInfoRequest(context.Background(), "owner/repo", "https://github.com/owner/repo.git", "main")
+	if !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("Expected ErrNotRecorded, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected unrecorded requests not to be retried")
+	}
+
+	// Replaying needs no token
+	player, err = LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DefaultCassette = player
+	defer func() { DefaultCassette = nil }()
+	cfg.AuthToken = ""
+	cfg.GithubToken = ""
+	info, err = NewClient(cfg).SendGetInfoRequest(context.Background(), "owner/repo", "https://github.com/owner/repo.git", "main")
+	if err != nil || info.FilesProcessed != 5 {
+		t.Errorf("Expected the recorded info without a token, got %+v, %v", info, err)
+	}
+}
+
+// Test that streamed responses arrive chunk by chunk while being recorded
+func TestCassette_RecordStream(t *testing.T) {
+	received := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"type": "message", "message": "Hello"}` + "\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Errorf("Expected the first chunk to be handled before the response completed")
+		}
+		w.Write([]byte(`{"type": "message", "message": " world"}` + "\n"))
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "test_token"
+
+	recorder := NewRecorder()
+	client := NewClient(cfg)
+	client.HTTPClient.Transport = recorder.Transport(client.HTTPClient.Transport)
+	var parts []string
+	_, err := client.StreamQueryRepoRequest(context.Background(), "owner/repo", "https://github.com/owner/repo.git", "main", "question", QueryOptions{}, func(text string) error {
+		if len(parts) == 0 {
+			close(received)
+		}
+		parts = append(parts, text)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to stream query: %v", err)
+	}
+	if strings.Join(parts, "|") != "Hello| world" {
+		t.Errorf("Expected the answer in two parts, got %q", parts)
+	}
+	if len(recorder.Interactions) != 1 || !strings.Contains(recorder.Interactions[0].Response.Body, " world") {
+		t.Errorf("Expected the whole streamed body to be recorded, got %+v", recorder.Interactions)
+	}
+}
+
+// Test that a conversation sends earlier turns under one session ID with
+// unique message IDs and the genius setting
+func TestConversation_Ask(t *testing.T) {
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		requests = append(requests, payload)
+		json.NewEncoder(w).Encode(QueryResponse{Message: fmt.Sprintf("answer %d", len(requests))})
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "test_token"
+	cfg.Genius = false
+
+	client := NewClient(cfg)
+	conv := NewConversation()
+	for _, question := range []string{"first", "second"} {
+		if _, err := client.Ask(context.Background(), conv, "owner/repo", "https://github.com/owner/repo.git", "main", question, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(conv.Messages) != 4 || conv.Messages[3].Role != RoleAssistant || conv.Messages[3].Content != "answer 2" {
+		t.Fatalf("Expected four turns ending with the second answer, got %+v", conv.Messages)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("Expected two requests, got %d", len(requests))
+	}
+	for _, payload := range requests {
+		if payload["sessionId"] != conv.SessionID {
+			t.Errorf("Expected session ID %s, got %v", conv.SessionID, payload["sessionId"])
+		}
+		if payload["genius"] != false {
+			t.Errorf("Expected genius false, got %v", payload["genius"])
+		}
+	}
+	if sent := requests[1]["messages"].([]interface{}); len(sent) != 3 {
+		t.Errorf("Expected the follow-up to send three messages, got %d", len(sent))
+	}
+
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := map[string]bool{conv.SessionID: true}
+	if !uuid.MatchString(conv.SessionID) {
+		t.Errorf("Expected a UUID session ID, got %s", conv.SessionID)
+	}
+	for _, message := range conv.Messages {
+		if !uuid.MatchString(message.ID) || seen[message.ID] {
+			t.Errorf("Expected a unique UUID message ID, got %s", message.ID)
+		}
+		seen[message.ID] = true
+	}
+}
+
+// Test that a self-hosted GitLab remote sends its token only in the GitLab
+// header, and that a host of unknown type sends no source control token
+func TestClient_SelfHostedRemote(t *testing.T) {
+	var headers []http.Header
+	var payloads []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		headers = append(headers, r.Header.Clone())
+		payloads = append(payloads, payload)
+		if r.URL.Path == "/search" {
+			w.Write([]byte(`[]`))
+		} else {
+			w.Write([]byte(`{"message": "answer"}`))
+		}
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "test_token"
+	cfg.GithubToken = "github_token"
+	cfg.SetRemoteToken("gitlab.example.com", "gitlab_token", config.SourceCredentials)
+	cfg.SetRemoteToken("git.example.org", "other_token", config.SourceCredentials)
+	if err := cfg.SetWithSource("RemoteHosts", "gitlab.example.com=gitlab", config.SourceFile); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(cfg)
+
+	if _, err := client.SendSearchRepoRequest(context.Background(), "team/app", "https://gitlab.example.com/team/app.git", "main", "search"); err != nil {
+		t.Fatalf("Expected the mapped self-hosted remote to be accepted, got %v", err)
+	}
+	if headers[0].Get("X-GitLab-Token") != "gitlab_token" || headers[0].Get("X-GitHub-Token") != "" {
+		t.Errorf("Expected only the GitLab header with the host's token, got %v", headers[0])
+	}
+	repos := payloads[0]["repositories"].([]interface{})
+	if repos[0].(map[string]interface{})["type"] != "gitlab" {
+		t.Errorf("Expected remote type gitlab, got %v", repos[0])
+	}
+	if upload := client.NewUploadRequest("team/app", "https://gitlab.example.com/team/app.git", "main", false, true); upload.Remote != "gitlab" {
+		t.Errorf("Expected the upload request for remote gitlab, got %s", upload.Remote)
+	}
+
+	if _, err := client.SendQueryRepoRequest(context.Background(), "team/app", "https://git.example.org/team/app.git", "main", "question", QueryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, header := range []string{"X-GitHub-Token", "X-GitLab-Token", "X-Azure-Token"} {
+		if headers[1].Get(header) != "" {
+			t.Errorf("Expected no %s for a host of unknown type, got %s", header, headers[1].Get(header))
+		}
+	}
+}
+
+// Test that reloading a branch or deleting an index isn't retried after a
+// server error, since the repeated request may act twice, while a plain index
+// request is
+func TestClient_NotRetried(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "test_token"
+	cfg.RetryMaxAttempts = 3
+	client := NewClient(cfg)
+	client.Retry.BaseDelay = time.Millisecond
+	client.Log = ioutil.Discard
+
+	remote := "https://github.com/owner/repo.git"
+	if err := client.SendIndexRequest(context.Background(), remote, client.NewUploadRequest("owner/repo", remote, "main", true, true)); err == nil {
+		t.Errorf("Expected the reload to fail")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected a failed reload not to be retried, got %d attempts", attempts)
+	}
+
+	attempts = 0
+	if err := client.SendIndexRequest(context.Background(), remote, client.NewUploadRequest("owner/repo", remote, "main", false, true)); err == nil {
+		t.Errorf("Expected the index request to fail")
+	}
+	if attempts != 3 {
+		t.Errorf("Expected the index request to be retried, got %d attempts", attempts)
+	}
+
+	attempts = 0
+	if err := client.SendDeleteRequest(context.Background(), "owner/repo", remote, "main"); err == nil {
+		t.Errorf("Expected the delete to fail")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected a failed delete not to be retried, got %d attempts", attempts)
+	}
+}
+
+// Test that the scope is added to the outgoing question only, and not kept
+// in the conversation or added to searches
+func TestClient_Scope(t *testing.T) {
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		requests = append(requests, payload)
+		if r.URL.Path == "/search" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`{"message": "answer"}`))
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "test_token"
+	cfg.Scope = []string{"src/", "lib/"}
+	client := NewClient(cfg)
+	remote := "https://github.com/owner/repo.git"
+
+	conv := NewConversation()
+	for _, question := range []string{"first", "second"} {
+		if _, err := client.Ask(context.Background(), conv, "owner/repo", remote, "main", question, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.SendSearchRepoRequest(context.Background(), "owner/repo", remote, "main", "search"); err != nil {
+		t.Fatal(err)
+	}
+
+	if conv.Messages[0].Content != "first" || conv.Messages[2].Content != "second" {
+		t.Errorf("Expected the questions to be kept as asked, got %+v", conv.Messages)
+	}
+	sent := requests[1]["messages"].([]interface{})
+	if content := sent[0].(map[string]interface{})["content"]; content != "first" {
+		t.Errorf("Expected the earlier question without the scope, got %q", content)
+	}
+	if content := sent[2].(map[string]interface{})["content"]; content != "second\n\nFocus on these paths: src/, lib/" {
+		t.Errorf("Expected the new question with the scope, got %q", content)
+	}
+	if query := requests[2]["query"]; query != "search" {
+		t.Errorf("Expected the search query unchanged, got %q", query)
+	}
+}
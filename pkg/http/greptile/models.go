@@ -0,0 +1,52 @@
+package greptile
+
+import "fmt"
+
+// Source is a piece of code that an answer refers to
+type Source struct {
+	Repository string `json:"repository"`
+	Remote     string `json:"remote,omitempty"`
+	Branch     string `json:"branch,omitempty"`
+	Filepath   string `json:"filepath"`
+	LineStart  int    `json:"linestart"`
+	LineEnd    int    `json:"lineend"`
+	Summary    string `json:"summary,omitempty"`
+}
+
+// QueryResponse is the answer to a semantic query
+type QueryResponse struct {
+	Message string   `json:"message"`
+	Sources []Source `json:"sources"`
+}
+
+// Message roles in a conversation
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is one turn of a conversation with the query endpoint
+type Message struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+	Role    string `json:"role"`
+}
+
+// SearchResult is a piece of code matching a search. A smaller distance
+// means a closer match.
+type SearchResult struct {
+	Source
+	Distance float64 `json:"distance"`
+}
+
+// Location formats the source as path:start-end
+func (s Source) Location() string {
+	switch {
+	case s.LineStart > 0 && s.LineEnd > s.LineStart:
+		return fmt.Sprintf("%s:%d-%d", s.Filepath, s.LineStart, s.LineEnd)
+	case s.LineStart > 0:
+		return fmt.Sprintf("%s:%d", s.Filepath, s.LineStart)
+	default:
+		return s.Filepath
+	}
+}
@@ -0,0 +1,111 @@
+package greptile
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides how often and how long failed requests are retried
+type RetryPolicy struct {
+	// Attempts per request including the first one; 1 disables retries
+	MaxAttempts int
+	// Longest total time spent on a request before giving up, 0 for no limit
+	MaxElapsed time.Duration
+	// Delay before the first retry, doubled for every further retry
+	BaseDelay time.Duration
+	// Upper bound for a single backoff delay
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used when the config doesn't say otherwise
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	MaxElapsed:  time.Minute,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// Statuses that mean the server did not handle the request and it may be
+// sent again, even if it is not idempotent
+var rejectedStatuses = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusServiceUnavailable: true,
+}
+
+// Statuses worth retrying for idempotent requests
+var transientStatuses = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// Helper to decide whether a failed attempt should be retried. Requests
+// that are not idempotent are only retried when the server refused them.
+func retryable(res *http.Response, err error, idempotent bool) bool {
+	if err != nil {
+		return idempotent && !errors.Is(err, ErrNotRecorded)
+	}
+	if idempotent {
+		return transientStatuses[res.StatusCode]
+	}
+	return rejectedStatuses[res.StatusCode]
+}
+
+// Helper to compute the delay before the given retry, starting at 1, with
+// full jitter over the upper half of the exponential backoff
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Helper to read the Retry-After header, given in seconds or as an HTTP date
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	}
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// Helper to describe why an attempt failed for the retry log
+func describeFailure(res *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return res.Status
+}
+
+// Helper to log a retry
+func (c *Client) logRetry(req *http.Request, reason string, delay time.Duration, attempt int) {
+	if c.Log == nil {
+		return
+	}
+	fmt.Fprintf(c.Log, "%s %s failed (%s); retrying in %s (attempt %d of %d)\n",
+		req.Method, req.URL.Path, reason, delay.Round(time.Millisecond), attempt, c.Retry.MaxAttempts)
+}
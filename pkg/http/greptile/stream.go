@@ -0,0 +1,100 @@
+package greptile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Types of streamed chunks
+const (
+	chunkMessage = "message"
+	chunkSources = "sources"
+	chunkStatus  = "status"
+)
+
+// chunk is one part of a streamed answer or search. Message holds text for
+// message and status chunks and a list of code references for sources chunks.
+type chunk struct {
+	Type    string          `json:"type"`
+	Message json.RawMessage `json:"message"`
+}
+
+// Helper to return the text of a message or status chunk
+func (c chunk) text() string {
+	var text string
+	if err := json.Unmarshal(c.Message, &text); err != nil {
+		return ""
+	}
+	return text
+}
+
+// Helper to read a stream of newline separated JSON chunks, passing each to
+// handle as soon as it is complete. Server-sent events are accepted: chunks
+// are read from data: lines, and event:, id:, retry: and comment lines are
+// skipped. A complete response sent by a server that doesn't stream is split
+// into its message and sources chunks, or is a single sources chunk when it
+// is a list.
+func readStream(ctx context.Context, body io.Reader, handle func(chunk) error) error {
+	reader := bufio.NewReader(body)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return networkError(ctx, "read streamed response", readErr)
+		}
+
+		line = bytes.TrimSpace(line)
+		isField := isEventField(line)
+		line = bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:")))
+		switch {
+		case isField || len(line) == 0 || bytes.Equal(line, []byte("[DONE]")):
+			// Event fields, keep-alives and the end marker carry nothing
+		case line[0] == '[':
+			if err := handle(chunk{Type: chunkSources, Message: line}); err != nil {
+				return err
+			}
+		default:
+			var decoded struct {
+				chunk
+				Sources json.RawMessage `json:"sources"`
+			}
+			if err := json.Unmarshal(line, &decoded); err != nil {
+				return fmt.Errorf("failed to parse streamed response: %v", err)
+			}
+
+			chunks := []chunk{decoded.chunk}
+			if decoded.Type == "" {
+				chunks = []chunk{{Type: chunkMessage, Message: decoded.Message}}
+				if decoded.Sources != nil {
+					chunks = append(chunks, chunk{Type: chunkSources, Message: decoded.Sources})
+				}
+			}
+			for _, c := range chunks {
+				if err := handle(c); err != nil {
+					return err
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Helper to tell server-sent event lines that carry no data, such as event
+// names, IDs, reconnection delays and comments
+func isEventField(line []byte) bool {
+	if bytes.HasPrefix(line, []byte(":")) {
+		return true
+	}
+	for _, field := range []string{"event:", "id:", "retry:"} {
+		if bytes.HasPrefix(line, []byte(field)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package greptile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceEnv names the environment variable that enables the HTTP trace, set
+// to 1 for stderr or to a file path, written as HAR when it ends in .har
+const TraceEnv = "CLIGUANA_TRACE_HTTP"
+
+// Longest body written to the readable trace; HAR files get the whole body
+const traceBodyLimit = 64 * 1024
+
+// Headers whose values never appear in a trace
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Github-Token":      true,
+	"X-Gitlab-Token":      true,
+	"X-Azure-Token":       true,
+}
+
+// DefaultTracer traces the requests of clients created by NewClient; nil
+// disables tracing
+var DefaultTracer *Tracer
+
+// Tracer records every request and response sent through its transport,
+// with secret header values redacted
+type Tracer struct {
+	// Log receives a readable trace of every exchange; nil disables it
+	Log io.Writer
+	// HAR collects every exchange for WriteHAR when set
+	HAR bool
+
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+// StartTrace sets DefaultTracer to trace to dest: "1", "true" or "stderr"
+// for stderr, otherwise a file that is written as HAR when its name ends in
+// .har. The returned function completes the trace and closes the file.
+func StartTrace(dest string) (func() error, error) {
+	switch strings.ToLower(dest) {
+	case "", "0", "false":
+		return func() error { return nil }, nil
+	case "1", "true", "stderr":
+		DefaultTracer = &Tracer{Log: os.Stderr}
+		return func() error { return nil }, nil
+	}
+
+	file, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %v", err)
+	}
+	if !strings.HasSuffix(strings.ToLower(dest), ".har") {
+		DefaultTracer = &Tracer{Log: file}
+		return file.Close, nil
+	}
+
+	tracer := &Tracer{HAR: true}
+	DefaultTracer = tracer
+	return func() error {
+		if err := tracer.WriteHAR(file); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write HAR file: %v", err)
+		}
+		return file.Close()
+	}, nil
+}
+
+// Transport wraps base so that every exchange it carries is traced
+func (t *Tracer) Transport(base http.RoundTripper) http.RoundTripper {
+	return &traceTransport{tracer: t, base: base}
+}
+
+type traceTransport struct {
+	tracer *Tracer
+	base   http.RoundTripper
+}
+
+func (tt *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := tt.tracer
+	start := time.Now()
+
+	// Read the request body through GetBody so the request itself is untouched
+	var reqBody []byte
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			reqBody, _ = ioutil.ReadAll(body)
+			body.Close()
+		}
+	}
+	t.logf("--> %s %s\n", req.Method, req.URL)
+	t.logHeaders(req.Header)
+	t.logBody(reqBody)
+
+	res, err := tt.base.RoundTrip(req)
+	wait := time.Since(start)
+	if err != nil {
+		t.logf("<-- %s %s failed after %s: %v\n\n", req.Method, req.URL, wait.Round(time.Millisecond), err)
+		t.record(req, reqBody, nil, nil, start, wait, 0)
+		return nil, err
+	}
+	t.logf("<-- %s %s (%s)\n", res.Status, req.URL, wait.Round(time.Millisecond))
+	t.logHeaders(res.Header)
+
+	// Streamed bodies are traced once they have been read and closed
+	res.Body = &tracedBody{ReadCloser: res.Body, done: func(body []byte) {
+		total := time.Since(start)
+		t.logBody(body)
+		t.logf("<-- end of body, %d bytes (%s total)\n\n", len(body), total.Round(time.Millisecond))
+		t.record(req, reqBody, res, body, start, wait, total-wait)
+	}}
+	return res, nil
+}
+
+// tracedBody keeps a copy of a response body as it is read
+type tracedBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	once sync.Once
+	done func([]byte)
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.buf.Bytes()) })
+	return err
+}
+
+// Helper to write to the readable trace, if any
+func (t *Tracer) logf(format string, args ...interface{}) {
+	if t.Log == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.Log, format, args...)
+}
+
+// Helper to write headers to the readable trace in a stable order
+func (t *Tracer) logHeaders(header http.Header) {
+	for _, h := range redactHeaders(header) {
+		t.logf("    %s: %s\n", h.Name, h.Value)
+	}
+}
+
+// Helper to write a body to the readable trace, shortening long ones
+func (t *Tracer) logBody(body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	if len(body) > traceBodyLimit {
+		t.logf("%s\n... %d more bytes\n", body[:traceBodyLimit], len(body)-traceBodyLimit)
+		return
+	}
+	t.logf("%s\n", bytes.TrimRight(body, "\n"))
+}
+
+// Helper to return headers sorted by name, with secret values redacted
+func redactHeaders(header http.Header) []harNameValue {
+	var headers []harNameValue
+	for name, values := range header {
+		for _, value := range values {
+			if redactedHeaders[http.CanonicalHeaderKey(name)] {
+				value = redactValue(value)
+			}
+			headers = append(headers, harNameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
+
+// Helper to hide a secret, keeping an auth scheme such as "Bearer"
+func redactValue(value string) string {
+	if scheme, _, ok := strings.Cut(value, " "); ok {
+		return scheme + " [REDACTED]"
+	}
+	return "[REDACTED]"
+}
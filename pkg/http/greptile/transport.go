@@ -0,0 +1,128 @@
+package greptile
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"cliguana/config"
+)
+
+// NewTransport builds a transport with the proxy and TLS settings in the
+// config that limits how long connecting may take. The overall deadline of
+// each operation is set on its context instead, so that long streamed
+// answers aren't cut off by a client-wide timeout.
+func NewTransport(cfg *config.Config) (*http.Transport, error) {
+	proxy, err := Proxy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := TLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	connect := time.Duration(cfg.ConnectTimeout)
+	dialer := &net.Dialer{
+		Timeout:   connect,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = connect
+	return transport, nil
+}
+
+// Proxy returns how API requests pick a proxy: ProxyURL when it is set,
+// otherwise the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables
+func Proxy(cfg *config.Config) (func(*http.Request) (*url.URL, error), error) {
+	if cfg.ProxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	proxyURL, err := url.Parse(cfg.ProxyURL)
+	if err != nil {
+		// The parse error repeats the URL, which may hold a password
+		return nil, fmt.Errorf("invalid ProxyURL %s", config.Redact(cfg.ProxyURL))
+	}
+	return http.ProxyURL(proxyURL), nil
+}
+
+// TLSConfig returns the TLS settings for API requests: the system roots plus
+// any CABundles, the client certificate for mutual TLS and MinTLSVersion
+func TLSConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.MinTLSVersion != "" {
+		version, ok := config.TLSVersions[cfg.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid MinTLSVersion %q", cfg.MinTLSVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if len(cfg.CABundles) > 0 {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		for _, path := range cfg.CABundles {
+			certs, err := ReadCABundle(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, cert := range certs {
+				roots.AddCert(cert)
+			}
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		if cfg.ClientCert == "" || cfg.ClientKey == "" {
+			return nil, fmt.Errorf("ClientCert and ClientKey must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// ReadCABundle reads the certificates in a PEM file of root CAs
+func ReadCABundle(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in CA bundle %s: %v", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return certs, nil
+}
@@ -1,6 +1,9 @@
 package index
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -8,14 +11,16 @@ import (
 	"strings"
 
 	"cliguana/config"
+	"cliguana/pkg/exitcode"
 	"cliguana/pkg/http/greptile"
+	"cliguana/pkg/repo"
 	"cliguana/pkg/util"
 )
 
 // Check if a repository is already in the autoupload list
 func isRepoInAutoupload(cfg *config.Config, repoPath string) bool {
-	for _, repo := range cfg.AutouploadRepos {
-		if repo.RepoPath == repoPath {
+	for _, dir := range cfg.AutouploadDirs {
+		if dir == repoPath {
 			return true
 		}
 	}
@@ -34,6 +39,9 @@ func AddRepoToAutoupload(cfg *config.Config, repoPath string) error {
 
 	// Add new directory
 	cfg.AutouploadDirs = append(cfg.AutouploadDirs, repoPath)
+	if err := config.SaveConfig(cfg); err != nil {
+		return err
+	}
 
 	fmt.Println("Directory added for autoupload:", repoPath)
 	return nil
@@ -56,28 +64,32 @@ func DeleteRepoFromAutoupload(cfg *config.Config, repoPath string) error {
 		return nil
 	}
 
+	cfg.AutouploadDirs = newDirs
+	if err := config.SaveConfig(cfg); err != nil {
+		return err
+	}
+
 	fmt.Println("Directory removed from autoupload:", repoPath)
 	return nil
 }
 
-// Trigger an API call to upload the repository
-func TriggerUploadAPI(cfg *config.Config, repoPath string) error {
-	// Get remote URL
-	remote := util.GetRemoteUrl(repoPath)
-	if remote == "" {
-		return fmt.Errorf("failed to get remote URL")
-	}
-
-	// Get current branch
-	branch := util.GetCurrentBranch(repoPath)
-	if branch == "" {
-		return fmt.Errorf("failed to get current branch")
-	}
+// UploadOptions tune the index requests TriggerUploadAPI sends
+type UploadOptions struct {
+	// Branches to index instead of the configured or checked out one
+	Branches []string
+	// Index branches again from scratch even if they are already indexed
+	Reload bool
+	// Don't send an email when indexing finishes
+	NoNotify bool
+	// Print the requests instead of sending them
+	DryRun bool
+}
 
-	// Extract repository name from remote URL
-	repository := util.ExtractRepoName(remote)
-	if repository == "" {
-		return fmt.Errorf("invalid remote URL: %s", remote)
+// Trigger an API call to upload the repository, once for every branch
+func TriggerUploadAPI(ctx context.Context, cfg *config.Config, repoPath string, opts UploadOptions) error {
+	r, cfg, err := repo.Resolve(ctx, cfg, repoPath)
+	if err != nil {
+		return err
 	}
 
 	// Check if the directory is a valid Git repository
@@ -85,42 +97,151 @@ func TriggerUploadAPI(cfg *config.Config, repoPath string) error {
 		return fmt.Errorf("the directory %s is not a valid Git repository", repoPath)
 	}
 
-	// Handle detached HEAD state
-	if branch == "HEAD" {
-		var branchCmd = exec.Command("git", "-C", repoPath, "rev-parse", "HEAD")
-		branchOutput, err := branchCmd.Output()
-		if err != nil {
-			fmt.Printf("Error getting current commit hash: %v\n", err)
-			return fmt.Errorf("failed to get current commit hash: %v", err)
+	branches := opts.Branches
+	if len(branches) == 0 {
+		branch := r.Branch
+
+		// Handle detached HEAD state
+		if branch == "HEAD" {
+			var branchCmd = exec.CommandContext(ctx, "git", "-C", repoPath, "rev-parse", "HEAD")
+			branchOutput, err := branchCmd.Output()
+			if err != nil {
+				fmt.Printf("Error getting current commit hash: %v\n", err)
+				return fmt.Errorf("failed to get current commit hash: %v", err)
+			}
+			branch = strings.TrimSpace(string(branchOutput))
+			fmt.Printf("Current commit hash: %s\n", branch)
 		}
-		branch = strings.TrimSpace(string(branchOutput))
-		fmt.Printf("Current commit hash: %s\n", branch)
+		branches = []string{branch}
 	}
 
 	// Map the remote URL to the appropriate remote type
-	remoteType := util.GetRemoteType(remote)
+	remoteType := cfg.RemoteType(r.Remote)
 	if remoteType == "" {
-		return fmt.Errorf("invalid remote URL: %s", remote)
+		return fmt.Errorf("invalid remote URL: %s", r.Remote)
 	}
 
-	return greptile.SendIndexRequest(cfg, repository, remoteType, branch)
+	client := greptile.NewClient(cfg)
+	for _, branch := range branches {
+		uploadRequest := client.NewUploadRequest(r.Repository, r.Remote, branch, opts.Reload, !opts.NoNotify)
+
+		if opts.DryRun {
+			payload, err := json.MarshalIndent(uploadRequest, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to marshal request: %v", err)
+			}
+			fmt.Printf("Would send POST %s/repositories:\n%s\n", client.APIRoot, payload)
+			continue
+		}
+
+		if len(branches) > 1 {
+			fmt.Printf("Indexing branch %s\n", branch)
+		}
+		if err := client.SendIndexRequest(ctx, r.Remote, uploadRequest); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Simulate an API call for deletion
-func TriggerDeleteAPI(cfg *config.Config, repoPath string) error {
-	// Simulated API call for deleting the repository
-	fmt.Printf("Triggering delete API for repository: %s\n", repoPath)
-	fmt.Print("Delete not implemented yet\n")
+// Trigger an API call to delete the index of the repository's branch, or of
+// every indexed branch when allBranches is set. Unless assumeYes is set, the
+// branches are listed and deletion must be confirmed.
+func TriggerDeleteAPI(ctx context.Context, cfg *config.Config, repoPath string, allBranches bool, assumeYes bool) error {
+	r, cfg, err := repo.Resolve(ctx, cfg, repoPath)
+	if err != nil {
+		return err
+	}
+
+	client := greptile.NewClient(cfg)
+	var indexed []string
+	if allBranches {
+		indexed, err = indexedBranches(ctx, cfg, client, r, repoPath)
+	} else {
+		indexed, err = filterIndexed(ctx, client, r, []string{r.Branch})
+	}
+	if err != nil {
+		return err
+	}
+	if len(indexed) == 0 && allBranches {
+		return exitcode.WithCode(exitcode.NotFound, fmt.Errorf("%s has no indexed branches", r.Repository))
+	}
+	if len(indexed) == 0 {
+		return exitcode.WithCode(exitcode.NotFound, fmt.Errorf("%s is not indexed for branch %s", r.Repository, r.Branch))
+	}
+
+	if !assumeYes {
+		fmt.Printf("This deletes the Greptile index of %s for branch %s.\n", r.Repository, strings.Join(indexed, ", "))
+		confirmed, err := util.Confirm(ctx, "Continue?")
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			return fmt.Errorf("aborted; nothing was deleted")
+		}
+	}
+
+	for _, branch := range indexed {
+		if err := client.SendDeleteRequest(ctx, r.Repository, r.Remote, branch); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted the index of %s for branch %s\n", r.Repository, branch)
+	}
 	return nil
 }
 
+// Helper to find every indexed branch of a repository. The API's list of
+// repositories also covers branches that only exist on the server; when the
+// API can't list repositories, the branches git knows are looked up instead.
+func indexedBranches(ctx context.Context, cfg *config.Config, client *greptile.Client, r *repo.Repo, repoPath string) ([]string, error) {
+	repos, err := client.ListRepositories(ctx)
+	if errors.Is(err, greptile.ErrListUnsupported) {
+		fmt.Fprintln(os.Stderr, "The Greptile API doesn't list repositories; checking the branches git knows instead.")
+		branches, err := util.GetBranches(ctx, repoPath, cfg.Remote)
+		if err != nil {
+			return nil, err
+		}
+		return filterIndexed(ctx, client, r, branches)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	remoteType := cfg.RemoteType(r.Remote)
+	var branches []string
+	for _, info := range repos {
+		if strings.EqualFold(info.Repository, r.Repository) && strings.EqualFold(info.Remote, remoteType) {
+			branches = append(branches, info.Branch)
+		}
+	}
+	return branches, nil
+}
+
+// Helper to keep the branches the API has an index for
+func filterIndexed(ctx context.Context, client *greptile.Client, r *repo.Repo, branches []string) ([]string, error) {
+	var indexed []string
+	for _, branch := range branches {
+		_, err := client.SendGetInfoRequest(ctx, r.Repository, r.Remote, branch)
+		var apiErr *greptile.APIError
+		if errors.As(err, &apiErr) && apiErr.Kind == greptile.KindNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		indexed = append(indexed, branch)
+	}
+	return indexed, nil
+}
+
 // Wrap the `git clone` command
-func GitCloneAndUpload(cfg *config.Config, repoURL string, repoPath string) error {
+func GitCloneAndUpload(ctx context.Context, cfg *config.Config, repoURL string, repoPath string, opts UploadOptions) error {
 	if repoPath == "." {
 		repoPath = util.ExtractRepoName(repoURL)
 	}
 
-	cmd := exec.Command("git", "clone", repoURL, repoPath)
+	// Name the remote the way the config expects to find it
+	cmd := exec.CommandContext(ctx, "git", "clone", "--origin", cfg.Remote, repoURL, repoPath)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
@@ -131,5 +252,5 @@ func GitCloneAndUpload(cfg *config.Config, repoURL string, repoPath string) erro
 
 	fmt.Println("Repository cloned successfully.")
 
-	return TriggerUploadAPI(cfg, repoPath)
+	return TriggerUploadAPI(ctx, cfg, repoPath, opts)
 }
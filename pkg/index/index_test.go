@@ -0,0 +1,245 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"cliguana/config"
+	"cliguana/pkg/exitcode"
+	"cliguana/pkg/http/greptile"
+	"cliguana/pkg/util"
+)
+
+// Helper to create a git checkout of github.com/owner/repo on branch main
+func newRepo(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cliguana_index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"remote", "add", "origin", "https://github.com/owner/repo.git"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "initial"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+	}
+	return dir
+}
+
+// apiRecorder is a fake API that records the requests it receives
+type apiRecorder struct {
+	mu       sync.Mutex
+	requests []string
+	bodies   []greptile.UploadRequest
+}
+
+// Helper to start a fake API answering with handle, and a config that uses it
+func newAPI(t *testing.T, handle func(w http.ResponseWriter, r *http.Request)) (*apiRecorder, *config.Config) {
+	api := &apiRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		api.requests = append(api.requests, r.Method+" "+r.URL.EscapedPath())
+		if r.Method == "POST" {
+			var upload greptile.UploadRequest
+			json.NewDecoder(r.Body).Decode(&upload)
+			api.bodies = append(api.bodies, upload)
+		}
+		api.mu.Unlock()
+		handle(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "test_token"
+	cfg.GithubToken = "github_token"
+	cfg.RetryMaxAttempts = 1
+	return api, cfg
+}
+
+// Helper to run f with stdout captured
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		output <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-output
+}
+
+// Test the index requests sent for --reload, --no-notify and several --branch values
+func TestTriggerUploadAPI(t *testing.T) {
+	dir := newRepo(t)
+	api, cfg := newAPI(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	captureStdout(t, func() {
+		if err := TriggerUploadAPI(context.Background(), cfg, dir, UploadOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		opts := UploadOptions{Branches: []string{"main", "dev"}, Reload: true, NoNotify: true}
+		if err := TriggerUploadAPI(context.Background(), cfg, dir, opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	expected := []greptile.UploadRequest{
+		{Remote: "github", Repository: "owner/repo", Branch: "main", Reload: false, Notify: true},
+		{Remote: "github", Repository: "owner/repo", Branch: "main", Reload: true, Notify: false},
+		{Remote: "github", Repository: "owner/repo", Branch: "dev", Reload: true, Notify: false},
+	}
+	if len(api.bodies) != len(expected) {
+		t.Fatalf("Expected %d index requests, got %+v", len(expected), api.bodies)
+	}
+	for i := range expected {
+		if api.bodies[i] != expected[i] {
+			t.Errorf("Expected request %d to be %+v, got %+v", i, expected[i], api.bodies[i])
+		}
+	}
+}
+
+// Test that --dry-run prints the requests without sending them
+func TestTriggerUploadAPI_DryRun(t *testing.T) {
+	dir := newRepo(t)
+	api, cfg := newAPI(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	output := captureStdout(t, func() {
+		opts := UploadOptions{Branches: []string{"main", "dev"}, Reload: true, DryRun: true}
+		if err := TriggerUploadAPI(context.Background(), cfg, dir, opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if len(api.requests) != 0 {
+		t.Errorf("Expected no requests in a dry run, got %v", api.requests)
+	}
+	if strings.Count(output, "Would send POST "+cfg.BaseURL+"/repositories") != 2 {
+		t.Errorf("Expected both requests to be printed, got:\n%s", output)
+	}
+	if !strings.Contains(output, `"branch": "dev"`) || !strings.Contains(output, `"reload": true`) {
+		t.Errorf("Expected the printed payload of the dev branch with reload, got:\n%s", output)
+	}
+}
+
+// Test that unindex asks for confirmation, deletes only after a yes, and
+// reports a branch that isn't indexed with exit code 4
+func TestTriggerDeleteAPI(t *testing.T) {
+	dir := newRepo(t)
+	indexed := true
+	api, cfg := newAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if !indexed {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(greptile.RepositoryInfo{Repository: "owner/repo", Branch: "main", Status: "completed"})
+	})
+	defer util.SetStdin(os.Stdin)
+
+	util.SetStdin(strings.NewReader("n\n"))
+	captureStdout(t, func() {
+		if err := TriggerDeleteAPI(context.Background(), cfg, dir, false, false); err == nil || !strings.Contains(err.Error(), "aborted") {
+			t.Errorf("Expected the deletion to be aborted, got %v", err)
+		}
+	})
+	util.SetStdin(strings.NewReader("y\n"))
+	captureStdout(t, func() {
+		if err := TriggerDeleteAPI(context.Background(), cfg, dir, false, false); err != nil {
+			t.Errorf("Expected the deletion to succeed, got %v", err)
+		}
+	})
+
+	expected := []string{
+		"GET /repositories/github:main:owner%2Frepo",
+		"GET /repositories/github:main:owner%2Frepo",
+		"DELETE /repositories/github:main:owner%2Frepo",
+	}
+	if strings.Join(api.requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected requests %v, got %v", expected, api.requests)
+	}
+
+	indexed = false
+	err := TriggerDeleteAPI(context.Background(), cfg, dir, false, true)
+	if code := exitcode.For(err); code != exitcode.NotFound {
+		t.Errorf("Expected exit code %d for a branch that isn't indexed, got %d (%v)", exitcode.NotFound, code, err)
+	}
+}
+
+// Test that --all-branches deletes every indexed branch the API lists,
+// including branches that don't exist locally
+func TestTriggerDeleteAPI_AllBranches(t *testing.T) {
+	dir := newRepo(t)
+	api, cfg := newAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/repositories" {
+			json.NewEncoder(w).Encode([]greptile.RepositoryInfo{
+				{Repository: "owner/repo", Remote: "github", Branch: "main"},
+				{Repository: "owner/repo", Remote: "github", Branch: "server-only"},
+				{Repository: "owner/other", Remote: "github", Branch: "main"},
+				{Repository: "owner/repo", Remote: "gitlab", Branch: "main"},
+			})
+		}
+	})
+
+	captureStdout(t, func() {
+		if err := TriggerDeleteAPI(context.Background(), cfg, dir, true, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	expected := []string{
+		"GET /repositories",
+		"DELETE /repositories/github:main:owner%2Frepo",
+		"DELETE /repositories/github:server-only:owner%2Frepo",
+	}
+	if strings.Join(api.requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected requests %v, got %v", expected, api.requests)
+	}
+}
+
+// Test that --all-branches checks the branches git knows when the API can't
+// list repositories
+func TestTriggerDeleteAPI_AllBranchesFallback(t *testing.T) {
+	dir := newRepo(t)
+	api, cfg := newAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repositories" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+
+	captureStdout(t, func() {
+		if err := TriggerDeleteAPI(context.Background(), cfg, dir, true, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	expected := []string{
+		"GET /repositories",
+		"GET /repositories/github:main:owner%2Frepo",
+		"DELETE /repositories/github:main:owner%2Frepo",
+	}
+	if strings.Join(api.requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected requests %v, got %v", expected, api.requests)
+	}
+}
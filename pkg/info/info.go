@@ -1,12 +1,13 @@
 package info
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"cliguana/config"
 	"cliguana/pkg/http/greptile"
-	"cliguana/pkg/util"
+	"cliguana/pkg/repo"
 )
 
 // Display a simple progress bar in the terminal
@@ -25,39 +26,47 @@ func displayProgressBar(percentage float64) {
 }
 
 // Function to fetch repository progress and calculate the percentage
-func CheckProgress(cfg *config.Config, repoPath string) (int, int, error) {
-	// Get remote URL
-	remote := util.GetRemoteUrl(repoPath)
-	if remote == "" {
-		return 0, 0, fmt.Errorf("failed to get remote URL")
-	}
-
-	// Get current branch
-	branch := util.GetCurrentBranch(repoPath)
-	if branch == "" {
-		return 0, 0, fmt.Errorf("failed to get current branch")
+func CheckProgress(ctx context.Context, cfg *config.Config, repoPath string) (int, int, error) {
+	r, cfg, err := repo.Resolve(ctx, cfg, repoPath)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	// Extract repository name from remote URL
-	repository := util.ExtractRepoName(remote)
-	if repository == "" {
-		return 0, 0, fmt.Errorf("invalid remote URL: %s", remote)
-	}
+	return fetchProgress(ctx, greptile.NewClient(cfg), r)
+}
 
-	repoInfo, err := greptile.SendGetInfoRequest(cfg, repository, remote, branch)
+// Helper to fetch the number of files processed and the total number of
+// files of a resolved repository
+func fetchProgress(ctx context.Context, client *greptile.Client, r *repo.Repo) (int, int, error) {
+	repoInfo, err := client.SendGetInfoRequest(ctx, r.Repository, r.Remote, r.Branch)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to get repository info: %v", err)
+		return 0, 0, err
 	}
-
-	// Return the number of files processed and the total number of files
 	return repoInfo.FilesProcessed, repoInfo.NumFiles, nil
 }
 
-// Function to repeatedly check progress until completion
-func MonitorProgress(cfg *config.Config, repoPath string) error {
+// Function to repeatedly check progress until completion or until ctx is
+// cancelled, in which case the progress bar line is ended before returning
+func MonitorProgress(ctx context.Context, cfg *config.Config, repoPath string) error {
+	// The repository doesn't change while indexing, so it is resolved once
+	// and only its info is polled
+	r, cfg, err := repo.Resolve(ctx, cfg, repoPath)
+	if err != nil {
+		return err
+	}
+	client := greptile.NewClient(cfg)
+
+	drawn := false
+	progress := 0.0
 	for {
-		filesProcessed, numFiles, err := CheckProgress(cfg, repoPath)
+		filesProcessed, numFiles, err := fetchProgress(ctx, client, r)
+		if ctx.Err() != nil {
+			break
+		}
 		if err != nil {
+			if drawn {
+				fmt.Println()
+			}
 			return err
 		}
 
@@ -65,20 +74,30 @@ func MonitorProgress(cfg *config.Config, repoPath string) error {
 		if numFiles == 0 {
 			return fmt.Errorf("number of files is zero, cannot calculate progress")
 		}
-		progress := (float64(filesProcessed) / float64(numFiles)) * 100
+		progress = (float64(filesProcessed) / float64(numFiles)) * 100
 
 		// Display the progress bar
 		displayProgressBar(progress)
+		drawn = true
 
 		// Check if the upload is complete
 		if filesProcessed >= numFiles {
 			fmt.Println("\nUpload complete!")
-			break
+			return nil
 		}
 
 		// Wait for a while before checking again
-		time.Sleep(4 * time.Second)
+		select {
+		case <-time.After(4 * time.Second):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
 
-	return nil
+	if drawn {
+		fmt.Println()
+	}
+	return fmt.Errorf("stopped monitoring at %.2f%%; indexing continues on the server: %w", progress, ctx.Err())
 }
@@ -0,0 +1,108 @@
+package list
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"cliguana/config"
+	"cliguana/pkg/http/greptile"
+	"cliguana/pkg/repo"
+)
+
+// Filter selects repositories by status and remote type. Empty lists match
+// every repository.
+type Filter struct {
+	Statuses []string
+	Remotes  []string
+}
+
+// Repositories lists the repositories indexed for the account. When the API
+// can't list them, the autoupload directories are looked up one by one
+// instead, skipping those that aren't indexed.
+func Repositories(ctx context.Context, cfg *config.Config) ([]greptile.RepositoryInfo, error) {
+	repos, err := greptile.NewClient(cfg).ListRepositories(ctx)
+	if !errors.Is(err, greptile.ErrListUnsupported) {
+		return repos, err
+	}
+
+	fmt.Fprintln(os.Stderr, "The Greptile API doesn't list repositories; showing the autoupload directories instead.")
+	repos = []greptile.RepositoryInfo{}
+	for _, dir := range cfg.AutouploadDirs {
+		r, dirCfg, err := repo.Resolve(ctx, cfg, dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", dir, err)
+			continue
+		}
+
+		info, err := greptile.NewClient(dirCfg).SendGetInfoRequest(ctx, r.Repository, r.Remote, r.Branch)
+		var apiErr *greptile.APIError
+		if errors.As(err, &apiErr) && apiErr.Kind == greptile.KindNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, info)
+	}
+	return repos, nil
+}
+
+// Apply returns the repositories that match the filter
+func (f Filter) Apply(repos []greptile.RepositoryInfo) []greptile.RepositoryInfo {
+	matched := []greptile.RepositoryInfo{}
+	for _, info := range repos {
+		if matches(f.Statuses, info.Status) && matches(f.Remotes, info.Remote) {
+			matched = append(matched, info)
+		}
+	}
+	return matched
+}
+
+// Helper to check a value against a list of accepted values, ignoring case
+func matches(accepted []string, value string) bool {
+	if len(accepted) == 0 {
+		return true
+	}
+	for _, candidate := range accepted {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the repositories as a table, or as a JSON array when asJSON
+// is set
+func Print(w io.Writer, repos []greptile.RepositoryInfo, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(repos)
+	}
+
+	if len(repos) == 0 {
+		fmt.Fprintln(w, "No repositories found.")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tBRANCH\tREMOTE\tSTATUS\tFILES\tSHA\tPRIVATE")
+	for _, info := range repos {
+		sha := info.Sha
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		private := "no"
+		if info.Private {
+			private = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d/%d\t%s\t%s\n", info.Repository, info.Branch, info.Remote, info.Status, info.FilesProcessed, info.NumFiles, sha, private)
+	}
+	return tw.Flush()
+}
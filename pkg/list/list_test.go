@@ -0,0 +1,38 @@
+package list
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"cliguana/pkg/http/greptile"
+)
+
+// Test filtering by status and remote type and the table output
+func TestFilterAndPrint(t *testing.T) {
+	repos := []greptile.RepositoryInfo{
+		{Repository: "owner/repo", Remote: "github", Branch: "main", Status: "completed", FilesProcessed: 10, NumFiles: 10, Sha: "0123456789abcdef", Private: true},
+		{Repository: "team/app", Remote: "gitlab", Branch: "dev", Status: "processing", FilesProcessed: 3, NumFiles: 40},
+	}
+
+	matched := Filter{Statuses: []string{"Completed"}}.Apply(repos)
+	if len(matched) != 1 || matched[0].Repository != "owner/repo" {
+		t.Errorf("Expected only owner/repo to be completed, got %+v", matched)
+	}
+	matched = Filter{Remotes: []string{"gitlab", "azure"}}.Apply(repos)
+	if len(matched) != 1 || matched[0].Repository != "team/app" {
+		t.Errorf("Expected only team/app on gitlab, got %+v", matched)
+	}
+
+	var out bytes.Buffer
+	if err := Print(&out, repos, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "REPOSITORY") {
+		t.Fatalf("Expected a header and two rows, got:\n%s", out.String())
+	}
+	if fields := strings.Fields(lines[1]); strings.Join(fields, " ") != "owner/repo main github completed 10/10 0123456 yes" {
+		t.Errorf("Unexpected row for owner/repo: %s", lines[1])
+	}
+}
@@ -0,0 +1,58 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"cliguana/config"
+	"cliguana/pkg/util"
+)
+
+// Repo identifies a local checkout the way the Greptile API names it
+type Repo struct {
+	Path       string
+	Remote     string
+	Branch     string
+	Repository string
+}
+
+// Resolve reads the remote, branch and repository name of the checkout at
+// repoPath. It also returns the config that applies to the checkout, with
+// its per-repository config file and matching profile applied.
+func Resolve(ctx context.Context, cfg *config.Config, repoPath string) (*Repo, *config.Config, error) {
+	cfg, err := cfg.ForRepo(repoPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Get remote URL
+	remote := util.GetRemoteUrl(ctx, repoPath, cfg.Remote)
+	if remote == "" {
+		return nil, nil, fmt.Errorf("failed to get remote URL")
+	}
+
+	// Switch to the profile matching this remote, if any
+	cfg = cfg.ForRemote(ctx, remote)
+
+	// Use the configured branch, or the current one
+	branch := cfg.Branch
+	if branch == "" {
+		branch = util.GetCurrentBranch(ctx, repoPath)
+	}
+	if branch == "" {
+		return nil, nil, fmt.Errorf("failed to get current branch")
+	}
+
+	// Extract repository name from remote URL
+	repository := util.ExtractRepoName(remote)
+	if repository == "" {
+		return nil, nil, fmt.Errorf("invalid remote URL: %s", remote)
+	}
+
+	return &Repo{
+		Path:       repoPath,
+		Remote:     remote,
+		Branch:     branch,
+		Repository: repository,
+	}, cfg, nil
+}
@@ -0,0 +1,299 @@
+package semantic
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"cliguana/config"
+	"cliguana/pkg/exitcode"
+	"cliguana/pkg/http/greptile"
+	"cliguana/pkg/repo"
+	"cliguana/pkg/session"
+	"cliguana/pkg/util"
+)
+
+// Lines of chat input kept between runs
+const chatHistorySize = 1000
+
+const chatHelp = `Ask a question about the repository, or use a command:
+  /sources    list the sources of the last answer
+  /open N     open source N in $VISUAL or $EDITOR, or print its lines
+  /genius     toggle genius mode; /genius on or /genius off sets it
+  /reset      start a new conversation
+  /save [ID]  save the conversation, optionally under a new session ID
+  /help       show this help
+  /exit       leave the chat (Ctrl-D also works)`
+
+// ChatOptions configure an interactive chat
+type ChatOptions struct {
+	// Session ID of a saved conversation to continue
+	Resume string
+	// Wait for complete answers instead of printing them as they arrive
+	NoStream bool
+}
+
+// chat is an interactive conversation about one repository
+type chat struct {
+	cfg     *config.Config
+	client  *greptile.Client
+	repo    *repo.Repo
+	session *session.Session
+	stream  bool
+	// Sources of the last answer, for /sources and /open
+	sources []greptile.Source
+}
+
+// HandleChat handles the chat command by reading questions at a prompt and
+// sending each with the conversation so far. The repository is resolved once
+// for the whole chat; an empty repoPath means the resumed session's checkout,
+// or the current directory. The session is saved after every answer.
+func HandleChat(ctx context.Context, cfg *config.Config, repoPath string, opts ChatOptions) error {
+	var s *session.Session
+	if opts.Resume != "" {
+		var err error
+		s, err = session.Load(opts.Resume)
+		if errors.Is(err, os.ErrNotExist) {
+			return exitcode.WithCode(exitcode.NotFound, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if repoPath == "" && s != nil {
+		repoPath = s.RepoPath
+	}
+	if repoPath == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current directory: %v", err)
+		}
+		repoPath = wd
+	}
+	if s == nil {
+		s = session.New(repoPath)
+	}
+	s.RepoPath = repoPath
+
+	r, cfg, err := repo.Resolve(ctx, cfg, repoPath)
+	if err != nil {
+		return err
+	}
+	c := &chat{cfg: cfg, client: greptile.NewClient(cfg), repo: r, session: s, stream: !opts.NoStream}
+
+	input := util.NewLineReader()
+	historyFile := filepath.Join(config.StateDir(), "chat_history")
+	if err := input.LoadHistory(historyFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	defer func() {
+		if err := os.MkdirAll(filepath.Dir(historyFile), 0700); err == nil {
+			err = input.SaveHistory(historyFile, chatHistorySize)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}()
+
+	fmt.Printf("Chatting about %s (%s) in session %s.\n", r.Repository, r.Branch, s.SessionID)
+	if turns := len(s.Messages) / 2; turns > 0 {
+		fmt.Printf("Resumed with %d earlier question(s).\n", turns)
+	}
+	fmt.Println("Type /help for commands.")
+
+	for {
+		line, err := input.ReadLine(ctx, "> ")
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		exit, err := c.handle(ctx, line)
+		if ctx.Err() != nil {
+			return err
+		}
+		if err != nil {
+			// Keep chatting after a failed question or command
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		if exit {
+			return nil
+		}
+	}
+}
+
+// Helper to run a command or ask a question, reporting whether to leave the chat
+func (c *chat) handle(ctx context.Context, line string) (bool, error) {
+	if line == "" {
+		return false, nil
+	}
+	if !strings.HasPrefix(line, "/") {
+		return false, c.ask(ctx, line)
+	}
+
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch command {
+	case "/exit", "/quit":
+		return true, nil
+	case "/help":
+		fmt.Println(chatHelp)
+	case "/sources":
+		if len(c.sources) == 0 {
+			fmt.Println("The last answer has no sources.")
+			return false, nil
+		}
+		printNumberedSources(c.sources)
+	case "/open":
+		return false, c.open(ctx, arg)
+	case "/genius":
+		switch arg {
+		case "":
+			c.cfg.Genius = !c.cfg.Genius
+		case "on":
+			c.cfg.Genius = true
+		case "off":
+			c.cfg.Genius = false
+		default:
+			return false, fmt.Errorf("usage: /genius [on|off]")
+		}
+		if c.cfg.Genius {
+			fmt.Println("Genius mode is on.")
+		} else {
+			fmt.Println("Genius mode is off.")
+		}
+	case "/reset":
+		c.session = session.New(c.session.RepoPath)
+		c.sources = nil
+		fmt.Printf("Started a new conversation in session %s.\n", c.session.SessionID)
+	case "/save":
+		previous := c.session.SessionID
+		if arg != "" {
+			c.session.SessionID = arg
+		}
+		if err := session.Save(c.session); err != nil {
+			c.session.SessionID = previous
+			return false, err
+		}
+		fmt.Printf("Saved session %s; continue it with `cliguana chat --resume %s`.\n", c.session.SessionID, c.session.SessionID)
+	default:
+		return false, fmt.Errorf("unknown command %s; type /help for commands", command)
+	}
+	return false, nil
+}
+
+// Helper to ask a question as the next turn of the conversation and save it
+func (c *chat) ask(ctx context.Context, question string) error {
+	var onText func(string) error
+	if c.stream {
+		onText = func(text string) error {
+			fmt.Print(text)
+			return nil
+		}
+	}
+
+	answer, err := c.client.Ask(ctx, &c.session.Conversation, c.repo.Repository, c.repo.Remote, c.repo.Branch, question, onText)
+	if c.stream && answer.Message != "" {
+		fmt.Println()
+	}
+	if err != nil {
+		return fmt.Errorf("error querying repository: %w", err)
+	}
+
+	if !c.stream {
+		fmt.Println(strings.TrimSpace(answer.Message))
+	}
+	c.sources = answer.Sources
+	if len(c.sources) > 0 {
+		fmt.Println()
+		printNumberedSources(c.sources)
+	}
+	return session.Save(c.session)
+}
+
+// Helper to open a source of the last answer in the user's editor at its
+// first line, or print its lines when no editor is set
+func (c *chat) open(ctx context.Context, arg string) error {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(c.sources) {
+		if len(c.sources) == 0 {
+			return fmt.Errorf("the last answer has no sources to open")
+		}
+		return fmt.Errorf("usage: /open N, where N is a source from 1 to %d", len(c.sources))
+	}
+	source := c.sources[n-1]
+	path := filepath.Join(c.repo.Path, filepath.FromSlash(source.Filepath))
+
+	// Source paths come from the API, so one like ../../.ssh/config must not
+	// open a file outside the repository
+	rel, err := filepath.Rel(c.repo.Path, path)
+	if err != nil || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("source %s is outside the repository", source.Filepath)
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		return printLines(path, source.LineStart, source.LineEnd)
+	}
+
+	// The editor setting may include arguments, such as "code --wait"
+	args := strings.Fields(editor)
+	if source.LineStart > 0 {
+		args = append(args, fmt.Sprintf("+%d", source.LineStart))
+	}
+	args = append(args, path)
+	editorCmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	editorCmd.Stdin = os.Stdin
+	editorCmd.Stdout = os.Stdout
+	editorCmd.Stderr = os.Stderr
+	if err := editorCmd.Run(); err != nil {
+		return fmt.Errorf("failed to run editor %s: %v", args[0], err)
+	}
+	return nil
+}
+
+// Helper to print lines start to end of a file with their numbers, or the
+// whole file when start is 0
+func printLines(path string, start int, end int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open source: %v", err)
+	}
+	defer file.Close()
+
+	if end < start {
+		end = start
+	}
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		if start > 0 && number < start {
+			continue
+		}
+		if start > 0 && number > end {
+			break
+		}
+		fmt.Printf("%5d  %s\n", number, scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// Helper to print the sources of an answer numbered for /open
+func printNumberedSources(sources []greptile.Source) {
+	fmt.Println("Sources:")
+	for i, source := range sources {
+		fmt.Printf("  [%d] %s\n", i+1, source.Location())
+	}
+}
@@ -0,0 +1,100 @@
+package semantic
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"cliguana/config"
+	"cliguana/pkg/http/greptile"
+	"cliguana/pkg/repo"
+	"cliguana/pkg/session"
+)
+
+// Test that chat questions share a session that is saved after each answer,
+// and that /genius, /reset and /save change the following requests
+func TestChat_Commands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cliguana_chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_STATE_HOME", dir)
+	defer os.Unsetenv("XDG_STATE_HOME")
+
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		requests = append(requests, payload)
+		json.NewEncoder(w).Encode(greptile.QueryResponse{
+			Message: "answer",
+			Sources: []greptile.Source{{Filepath: "main.go", LineStart: 1, LineEnd: 2}},
+		})
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.BaseURL = server.URL
+	cfg.AuthToken = "test_token"
+	cfg.Genius = true
+	c := &chat{
+		cfg:     cfg,
+		client:  greptile.NewClient(cfg),
+		repo:    &repo.Repo{Path: dir, Remote: "https://github.com/owner/repo.git", Branch: "main", Repository: "owner/repo"},
+		session: session.New(dir),
+	}
+	first := c.session.SessionID
+
+	for _, line := range []string{"first", "/genius", "second", "/reset", "third", "/save named"} {
+		if exit, err := c.handle(context.Background(), line); err != nil || exit {
+			t.Fatalf("Expected %q to succeed, got %v, %v", line, exit, err)
+		}
+	}
+	if exit, _ := c.handle(context.Background(), "/exit"); !exit {
+		t.Errorf("Expected /exit to leave the chat")
+	}
+	if _, err := c.handle(context.Background(), "/unknown"); err == nil {
+		t.Errorf("Expected an unknown command to fail")
+	}
+	if _, err := c.handle(context.Background(), "/open 2"); err == nil {
+		t.Errorf("Expected opening a missing source to fail")
+	}
+
+	if len(requests) != 3 {
+		t.Fatalf("Expected three queries, got %d", len(requests))
+	}
+	if requests[0]["genius"] != true || requests[1]["genius"] != false {
+		t.Errorf("Expected /genius to turn genius mode off, got %v then %v", requests[0]["genius"], requests[1]["genius"])
+	}
+	if requests[1]["sessionId"] != first || len(requests[1]["messages"].([]interface{})) != 3 {
+		t.Errorf("Expected the second question to follow the first in session %s, got %v", first, requests[1])
+	}
+	if requests[2]["sessionId"] == first || len(requests[2]["messages"].([]interface{})) != 1 {
+		t.Errorf("Expected /reset to start a new conversation, got %v", requests[2])
+	}
+
+	saved, err := session.Load(first)
+	if err != nil || len(saved.Messages) != 4 {
+		t.Errorf("Expected the first conversation saved with four turns, got %+v, %v", saved, err)
+	}
+	named, err := session.Load("named")
+	if err != nil || len(named.Messages) != 2 || named.RepoPath != dir {
+		t.Errorf("Expected /save to save the new conversation as named, got %+v, %v", named, err)
+	}
+
+	// Sources outside the repository are refused before any editor runs
+	os.Setenv("VISUAL", "false")
+	defer os.Unsetenv("VISUAL")
+	c.sources = []greptile.Source{{Filepath: "../../.ssh/config", LineStart: 1}, {Filepath: ".."}}
+	for _, arg := range []string{"/open 1", "/open 2"} {
+		if _, err := c.handle(context.Background(), arg); err == nil || !strings.Contains(err.Error(), "outside the repository") {
+			t.Errorf("Expected %s to refuse a path outside the repository, got %v", arg, err)
+		}
+	}
+}
@@ -1,71 +1,107 @@
 package semantic
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"cliguana/config"
 	"cliguana/pkg/http/greptile"
-	"cliguana/pkg/util"
+	"cliguana/pkg/repo"
 )
 
-// handleQuery handles the query command by sending the query to the Greptile API and displaying the results
-func HandleQuery(cfg *config.Config, semanticQuery string, repoPath string) error {
-	// Get remote URL
-	remote := util.GetRemoteUrl(repoPath)
-	if remote == "" {
-		return fmt.Errorf("failed to get remote URL")
+// handleQuery handles the query command by sending the query to the Greptile API and displaying the results.
+// With stream set, the answer is printed as it arrives and the sources once it is complete.
+// The query is asked as a follow-up in conv, which gains the new turns; a nil conv starts a new conversation.
+func HandleQuery(ctx context.Context, cfg *config.Config, semanticQuery string, repoPath string, stream bool, conv *greptile.Conversation) error {
+	r, cfg, err := repo.Resolve(ctx, cfg, repoPath)
+	if err != nil {
+		return err
 	}
-
-	// Get current branch
-	branch := util.GetCurrentBranch(repoPath)
-	if branch == "" {
-		return fmt.Errorf("failed to get current branch")
+	client := greptile.NewClient(cfg)
+	if conv == nil {
+		conv = greptile.NewConversation()
 	}
 
-	// Extract repository name from remote URL
-	repository := util.ExtractRepoName(remote)
-	if repository == "" {
-		return fmt.Errorf("invalid remote URL: %s", remote)
+	var onText func(string) error
+	if stream {
+		onText = func(text string) error {
+			fmt.Print(text)
+			return nil
+		}
 	}
 
 	// Send the query request to the Greptile API
-	response, err := greptile.SendQueryRepoRequest(cfg, repository, remote, branch, semanticQuery)
+	answer, err := client.Ask(ctx, conv, r.Repository, r.Remote, r.Branch, semanticQuery, onText)
+	if stream && answer.Message != "" {
+		fmt.Println()
+	}
 	if err != nil {
-		return fmt.Errorf("error querying repository: %v", err)
+		return fmt.Errorf("error querying repository: %w", err)
 	}
 
 	// Display the response
-	fmt.Println("Query Response:", response)
+	if !stream {
+		fmt.Println(strings.TrimSpace(answer.Message))
+	}
+	printSources(answer.Sources)
 	return nil
 }
 
-// handleSearch handles the search command by sending the search query to the Greptile API and displaying the results
-func HandleSearch(cfg *config.Config, searchQuery string, repoPath string) error {
-	// Get remote URL
-	remote := util.GetRemoteUrl(repoPath)
-	if remote == "" {
-		return fmt.Errorf("failed to get remote URL")
+// handleSearch handles the search command by sending the search query to the Greptile API and displaying the results.
+// With stream set, results are printed as they arrive.
+func HandleSearch(ctx context.Context, cfg *config.Config, searchQuery string, repoPath string, stream bool) error {
+	r, cfg, err := repo.Resolve(ctx, cfg, repoPath)
+	if err != nil {
+		return err
 	}
+	client := greptile.NewClient(cfg)
 
-	// Get current branch
-	branch := util.GetCurrentBranch(repoPath)
-	if branch == "" {
-		return fmt.Errorf("failed to get current branch")
+	var results []greptile.SearchResult
+	if stream {
+		results, err = client.StreamSearchRepoRequest(ctx, r.Repository, r.Remote, r.Branch, searchQuery, func(batch []greptile.SearchResult) error {
+			printResults(batch)
+			return nil
+		})
+	} else {
+		// Send the search request to the Greptile API
+		results, err = client.SendSearchRepoRequest(ctx, r.Repository, r.Remote, r.Branch, searchQuery)
+		if err == nil {
+			printResults(results)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error searching repository: %w", err)
 	}
 
-	// Extract repository name from remote URL
-	repository := util.ExtractRepoName(remote)
-	if repository == "" {
-		return fmt.Errorf("invalid remote URL: %s", remote)
+	if len(results) == 0 {
+		fmt.Println("No results found.")
 	}
+	return nil
+}
 
-	// Send the search request to the Greptile API
-	response, err := greptile.SendSearchRepoRequest(cfg, repository, remote, branch, searchQuery)
-	if err != nil {
-		return fmt.Errorf("error searching repository: %v", err)
+// Helper to print the sources of an answer
+func printSources(sources []greptile.Source) {
+	if len(sources) == 0 {
+		return
 	}
+	fmt.Println()
+	fmt.Println("Sources:")
+	for _, source := range sources {
+		fmt.Println("  " + source.Location())
+	}
+}
 
-	// Display the response
-	fmt.Println("Search Response:", response)
-	return nil
+// Helper to print search results with their distance and summary
+func printResults(results []greptile.SearchResult) {
+	for _, result := range results {
+		if result.Distance > 0 {
+			fmt.Printf("%s (distance %.3f)\n", result.Location(), result.Distance)
+		} else {
+			fmt.Println(result.Location())
+		}
+		if summary := strings.TrimSpace(result.Summary); summary != "" {
+			fmt.Println("  " + summary)
+		}
+	}
 }
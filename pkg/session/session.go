@@ -0,0 +1,121 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"cliguana/config"
+	"cliguana/pkg/http/greptile"
+)
+
+// Session IDs double as file names, so they are limited to safe characters
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Session is a saved conversation about a repository
+type Session struct {
+	greptile.Conversation
+	// Checkout the conversation is about
+	RepoPath string    `json:"repoPath,omitempty"`
+	Updated  time.Time `json:"updated"`
+}
+
+// New starts a session about the checkout at repoPath with a new ID
+func New(repoPath string) *Session {
+	return &Session{Conversation: *greptile.NewConversation(), RepoPath: repoPath}
+}
+
+// Dir returns the directory sessions are stored in
+func Dir() string {
+	return filepath.Join(config.StateDir(), "sessions")
+}
+
+// Helper to return the file of a session, rejecting IDs that aren't safe
+// file names
+func path(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", fmt.Errorf("invalid session ID %q: use letters, digits, '.', '_' and '-'", id)
+	}
+	return filepath.Join(Dir(), id+".json"), nil
+}
+
+// Load reads a saved session. A session that was never saved is an error
+// matching os.ErrNotExist.
+func Load(id string) (*Session, error) {
+	file, err := path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no saved session %s: %w", id, os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %v", err)
+	}
+
+	s := &Session{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %v", file, err)
+	}
+	if s.SessionID == "" {
+		s.SessionID = id
+	}
+	return s, nil
+}
+
+// Save writes the session to the sessions directory, readable only by the
+// user since conversations may quote private code
+func Save(s *Session) error {
+	file, err := path(s.SessionID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		return fmt.Errorf("failed to create sessions directory: %v", err)
+	}
+
+	s.Updated = time.Now().UTC()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %v", err)
+	}
+	if err := ioutil.WriteFile(file, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to save session: %v", err)
+	}
+	return nil
+}
+
+// LoadHistory reads earlier turns of a conversation from a JSON file holding
+// either a list of messages or an object with "messages" and optionally
+// "sessionId", such as a saved session. Messages without an ID get one.
+func LoadHistory(file string) (*greptile.Conversation, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+
+	conv := &greptile.Conversation{}
+	if err := json.Unmarshal(data, &conv.Messages); err != nil {
+		if err := json.Unmarshal(data, conv); err != nil {
+			return nil, fmt.Errorf("failed to parse history %s: expected a list of messages or an object with messages", file)
+		}
+	}
+
+	for i, message := range conv.Messages {
+		if message.Role != greptile.RoleUser && message.Role != greptile.RoleAssistant {
+			return nil, fmt.Errorf("history %s: message %d has role %q, expected %s or %s", file, i+1, message.Role, greptile.RoleUser, greptile.RoleAssistant)
+		}
+		if message.ID == "" {
+			conv.Messages[i].ID = greptile.NewID()
+		}
+	}
+	if conv.SessionID == "" {
+		conv.SessionID = greptile.NewID()
+	}
+	return conv, nil
+}
@@ -0,0 +1,79 @@
+package session
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cliguana/pkg/http/greptile"
+)
+
+// Test that a saved session loads back with its turns, and that unknown and
+// unsafe IDs are rejected
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cliguana_session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_STATE_HOME", dir)
+	defer os.Unsetenv("XDG_STATE_HOME")
+
+	s := New("/src/app")
+	s.Messages = append(s.Messages, greptile.Message{ID: "1", Content: "question", Role: greptile.RoleUser})
+	if err := Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(s.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.RepoPath != "/src/app" || len(loaded.Messages) != 1 || loaded.Messages[0].Content != "question" {
+		t.Errorf("Expected the saved session, got %+v", loaded)
+	}
+
+	if _, err := Load("missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist for an unknown session, got %v", err)
+	}
+	if _, err := Load("../escape"); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected an invalid ID error, got %v", err)
+	}
+}
+
+// Test that history files may be a list of messages or a saved session
+func TestLoadHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cliguana_history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	list := filepath.Join(dir, "list.json")
+	ioutil.WriteFile(list, []byte(`[{"content": "question", "role": "user"}, {"content": "answer", "role": "assistant"}]`), 0644)
+	conv, err := LoadHistory(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conv.Messages) != 2 || conv.Messages[0].ID == "" || conv.SessionID == "" {
+		t.Errorf("Expected two messages with generated IDs and a session ID, got %+v", conv)
+	}
+
+	object := filepath.Join(dir, "object.json")
+	ioutil.WriteFile(object, []byte(`{"sessionId": "abc", "messages": [{"id": "1", "content": "question", "role": "user"}]}`), 0644)
+	conv, err = LoadHistory(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.SessionID != "abc" || len(conv.Messages) != 1 || conv.Messages[0].ID != "1" {
+		t.Errorf("Expected session abc with one message, got %+v", conv)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	ioutil.WriteFile(invalid, []byte(`[{"content": "question", "role": "system"}]`), 0644)
+	if _, err := LoadHistory(invalid); err == nil {
+		t.Errorf("Expected an unknown role to be rejected")
+	}
+}
@@ -0,0 +1,365 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// LineReader reads lines typed at a prompt. On a terminal with stty, lines
+// can be edited with the arrow keys, Home, End, Backspace, Delete, Ctrl-A,
+// Ctrl-E, Ctrl-U and Ctrl-W, and earlier lines recalled with Up and Down.
+// Ctrl-C clears the line and Ctrl-D on an empty line ends the input.
+// Elsewhere lines are read as they are.
+type LineReader struct {
+	// Earlier lines, oldest first
+	History []string
+
+	in       *bufio.Reader
+	out      io.Writer
+	terminal bool
+	// Result of the read in progress. Only one byte is read at a time, so
+	// nothing is read ahead of other programs such as an editor, and a read
+	// outlives a cancelled ReadLine without losing input.
+	bytes   chan readByte
+	pending bool
+}
+
+type readByte struct {
+	b   byte
+	err error
+}
+
+// NewLineReader creates a reader for lines typed on stdin
+func NewLineReader() *LineReader {
+	terminal := false
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 && runtime.GOOS != "windows" {
+		_, err := stty("-g")
+		terminal = err == nil
+	}
+	return newLineReader(stdinReader, os.Stdout, terminal)
+}
+
+// Helper to create a line reader on any input, editing lines as on a
+// terminal when terminal is set
+func newLineReader(in *bufio.Reader, out io.Writer, terminal bool) *LineReader {
+	return &LineReader{in: in, out: out, terminal: terminal}
+}
+
+// LoadHistory reads earlier lines from a file with one line per entry. A
+// missing file is an empty history.
+func (r *LineReader) LoadHistory(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read history: %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			r.History = append(r.History, line)
+		}
+	}
+	return nil
+}
+
+// SaveHistory writes the last max lines of the history to a file
+func (r *LineReader) SaveHistory(path string, max int) error {
+	history := r.History
+	if len(history) > max {
+		history = history[len(history)-max:]
+	}
+	data := strings.Join(history, "\n") + "\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		return fmt.Errorf("failed to save history: %v", err)
+	}
+	return nil
+}
+
+// ReadLine prints the prompt and reads one line, adding it to the history
+// unless it is blank or repeats the previous line. It returns io.EOF at the
+// end of the input, and gives up when ctx is cancelled.
+func (r *LineReader) ReadLine(ctx context.Context, prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+
+	var line string
+	var err error
+	if r.terminal {
+		line, err = r.editLine(ctx, prompt)
+	} else {
+		line, err = r.readPlain(ctx)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	line = strings.TrimSpace(line)
+	if line != "" && (len(r.History) == 0 || r.History[len(r.History)-1] != line) {
+		r.History = append(r.History, line)
+	}
+	return line, nil
+}
+
+// Helper to read the next byte of input, giving up when ctx is cancelled
+func (r *LineReader) readByte(ctx context.Context) (byte, error) {
+	if r.bytes == nil {
+		r.bytes = make(chan readByte, 1)
+	}
+	if !r.pending {
+		r.pending = true
+		go func() {
+			b, err := r.in.ReadByte()
+			r.bytes <- readByte{b, err}
+		}()
+	}
+
+	select {
+	case rb := <-r.bytes:
+		r.pending = false
+		return rb.b, rb.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// Helper to read a line without editing. A last line without a newline is
+// accepted.
+func (r *LineReader) readPlain(ctx context.Context) (string, error) {
+	var line []byte
+	for {
+		b, err := r.readByte(ctx)
+		if err == io.EOF && len(line) > 0 {
+			return string(line), nil
+		}
+		if err == io.EOF {
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+		if b == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b)
+	}
+}
+
+// Helper to run stty on the terminal
+func stty(args ...string) (string, error) {
+	sttyCmd := exec.Command("stty", args...)
+	sttyCmd.Stdin = os.Stdin
+	out, err := sttyCmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// Helper to read a line on a terminal, reading each key as it is typed and
+// redrawing the line as it is edited
+func (r *LineReader) editLine(ctx context.Context, prompt string) (string, error) {
+	if r.in == stdinReader {
+		state, err := stty("-g")
+		if err != nil {
+			return r.readPlain(ctx)
+		}
+		if _, err := stty("-icanon", "-echo", "-isig", "min", "1"); err != nil {
+			return r.readPlain(ctx)
+		}
+		defer stty(state)
+	}
+
+	e := &lineEditor{out: r.out, prompt: prompt, history: r.History, index: len(r.History)}
+	for {
+		b, err := r.readByte(ctx)
+		if err == io.EOF && len(e.line) > 0 {
+			fmt.Fprintln(r.out)
+			return string(e.line), nil
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(r.out)
+			}
+			return "", err
+		}
+
+		switch b {
+		case '\r', '\n':
+			fmt.Fprintln(r.out)
+			return string(e.line), nil
+		case 4: // Ctrl-D
+			if len(e.line) == 0 {
+				fmt.Fprintln(r.out)
+				return "", io.EOF
+			}
+			e.deleteForward()
+		case 3: // Ctrl-C
+			fmt.Fprintln(r.out, "^C")
+			e.line, e.pos, e.index = nil, 0, len(r.History)
+			fmt.Fprint(r.out, prompt)
+		case 127, 8: // Backspace
+			e.deleteBackward()
+		case 1: // Ctrl-A
+			e.moveTo(0)
+		case 5: // Ctrl-E
+			e.moveTo(len(e.line))
+		case 21: // Ctrl-U
+			e.line = append([]rune{}, e.line[e.pos:]...)
+			e.pos = 0
+			e.redraw()
+		case 23: // Ctrl-W
+			e.deleteWord()
+		case 27: // Escape sequences for arrows, Home, End and Delete
+			if err := r.escape(ctx, e); err != nil {
+				return "", err
+			}
+		default:
+			if b < 32 {
+				continue
+			}
+			buf := []byte{b}
+			for !utf8.FullRune(buf) {
+				next, err := r.readByte(ctx)
+				if err != nil {
+					return "", err
+				}
+				buf = append(buf, next)
+			}
+			char, _ := utf8.DecodeRune(buf)
+			e.insert(char)
+		}
+	}
+}
+
+// Helper to read and apply the rest of an escape sequence
+func (r *LineReader) escape(ctx context.Context, e *lineEditor) error {
+	b, err := r.readByte(ctx)
+	if err != nil {
+		return err
+	}
+	if b != '[' && b != 'O' {
+		return nil
+	}
+
+	// Parameters such as the 3 of Delete's ESC [ 3 ~ come before the final byte
+	var params []byte
+	for {
+		b, err = r.readByte(ctx)
+		if err != nil {
+			return err
+		}
+		if b < '0' || b > '9' {
+			break
+		}
+		params = append(params, b)
+	}
+
+	switch {
+	case b == 'A':
+		e.recall(-1)
+	case b == 'B':
+		e.recall(1)
+	case b == 'C':
+		e.moveTo(e.pos + 1)
+	case b == 'D':
+		e.moveTo(e.pos - 1)
+	case b == 'H' || (b == '~' && (string(params) == "1" || string(params) == "7")):
+		e.moveTo(0)
+	case b == 'F' || (b == '~' && (string(params) == "4" || string(params) == "8")):
+		e.moveTo(len(e.line))
+	case b == '~' && string(params) == "3":
+		e.deleteForward()
+	}
+	return nil
+}
+
+// lineEditor holds a line being edited on a terminal
+type lineEditor struct {
+	out     io.Writer
+	prompt  string
+	line    []rune
+	pos     int
+	history []string
+	// Position in the history of the recalled line; len(history) is the
+	// line being typed
+	index int
+	// The line being typed while browsing the history
+	draft []rune
+}
+
+// Helper to redraw the prompt and line and put the cursor back in place
+func (e *lineEditor) redraw() {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, string(e.line))
+	if back := len(e.line) - e.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func (e *lineEditor) insert(char rune) {
+	e.line = append(e.line[:e.pos], append([]rune{char}, e.line[e.pos:]...)...)
+	e.pos++
+	e.redraw()
+}
+
+func (e *lineEditor) moveTo(pos int) {
+	if pos < 0 || pos > len(e.line) {
+		return
+	}
+	e.pos = pos
+	e.redraw()
+}
+
+func (e *lineEditor) deleteBackward() {
+	if e.pos == 0 {
+		return
+	}
+	e.line = append(e.line[:e.pos-1], e.line[e.pos:]...)
+	e.pos--
+	e.redraw()
+}
+
+func (e *lineEditor) deleteForward() {
+	if e.pos == len(e.line) {
+		return
+	}
+	e.line = append(e.line[:e.pos], e.line[e.pos+1:]...)
+	e.redraw()
+}
+
+// Helper to delete the word before the cursor and the spaces after it
+func (e *lineEditor) deleteWord() {
+	start := e.pos
+	for start > 0 && e.line[start-1] == ' ' {
+		start--
+	}
+	for start > 0 && e.line[start-1] != ' ' {
+		start--
+	}
+	e.line = append(e.line[:start], e.line[e.pos:]...)
+	e.pos = start
+	e.redraw()
+}
+
+// Helper to replace the line with an older (-1) or newer (1) history entry
+func (e *lineEditor) recall(step int) {
+	index := e.index + step
+	if index < 0 || index > len(e.history) {
+		return
+	}
+	if e.index == len(e.history) {
+		e.draft = e.line
+	}
+	e.index = index
+	if index == len(e.history) {
+		e.line = e.draft
+	} else {
+		e.line = []rune(e.history[index])
+	}
+	e.pos = len(e.line)
+	e.redraw()
+}
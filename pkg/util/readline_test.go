@@ -0,0 +1,65 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Test that lines typed on a terminal are edited and recalled from history
+func TestLineReader_Edit(t *testing.T) {
+	input := strings.Join([]string{
+		"abc\x1b[D\x1b[DX\r",     // insert before the cursor: aXbc
+		"hello wor\x17there\r",   // Ctrl-W deletes a word: hello there
+		"\x1b[A\x1b[A\x7f\x7f\r", // recall aXbc and delete two characters: aX
+		"drop\x03keep\x01>\r",    // Ctrl-C clears the line, Ctrl-A goes to the start: >keep
+		"\xc3\xa9t\xc3\xa9\r",    // multibyte characters: été
+		"\x04",                   // Ctrl-D on an empty line ends the input
+	}, "")
+	r := newLineReader(bufio.NewReader(strings.NewReader(input)), &bytes.Buffer{}, true)
+
+	expected := []string{"aXbc", "hello there", "aX", ">keep", "été"}
+	for _, want := range expected {
+		line, err := r.ReadLine(context.Background(), "> ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != want {
+			t.Errorf("Expected %q, got %q", want, line)
+		}
+	}
+	if _, err := r.ReadLine(context.Background(), "> "); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF after Ctrl-D, got %v", err)
+	}
+	if len(r.History) != len(expected) {
+		t.Errorf("Expected every line in the history, got %q", r.History)
+	}
+}
+
+// Test that lines are read as they are off a terminal
+func TestLineReader_Plain(t *testing.T) {
+	r := newLineReader(bufio.NewReader(strings.NewReader("first\n\nfirst\nlast")), &bytes.Buffer{}, false)
+
+	var lines []string
+	for {
+		line, err := r.ReadLine(context.Background(), "> ")
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if strings.Join(lines, ",") != "first,,first,last" {
+		t.Errorf("Expected the four lines, got %q", lines)
+	}
+	// Blank lines and repeats aren't added to the history
+	if strings.Join(r.History, ",") != "first,last" {
+		t.Errorf("Expected history [first last], got %q", r.History)
+	}
+}
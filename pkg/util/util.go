@@ -1,7 +1,12 @@
 package util
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"runtime"
 	"strings"
@@ -32,6 +37,26 @@ func ExtractRepoName(remote string) string {
 	return repo
 }
 
+// GetRemoteHost extracts the host name from a remote URL
+func GetRemoteHost(remote string) string {
+	// Example remote URLs:
+	// HTTPS: https://user@github.com:443/owner/repo.git
+	// SSH: git@github.com:owner/repo.git
+
+	var host string
+	if i := strings.Index(remote, "://"); i >= 0 {
+		host = strings.SplitN(remote[i+3:], "/", 2)[0]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		host = strings.SplitN(host, ":", 2)[0]
+	} else if strings.HasPrefix(remote, "git@") {
+		host = strings.SplitN(strings.TrimPrefix(remote, "git@"), ":", 2)[0]
+	}
+
+	return strings.ToLower(host)
+}
+
 // ExtractRepoNameFromURL extracts the repository name from the URL
 func ExtractRepoNameFromURL(repoURL string) string {
 	parts := strings.Split(repoURL, "/")
@@ -51,30 +76,147 @@ func GetRemoteType(remote string) string {
 	return ""
 }
 
-func GetRemoteUrl(absPath string) string {
+// GetRemoteUrl returns the URL of the named git remote
+func GetRemoteUrl(ctx context.Context, absPath string, remoteName string) string {
 	var remoteCmd *exec.Cmd
 	if runtime.GOOS == "windows" {
-		remoteCmd = exec.Command("git", "-C", absPath, "remote", "get-url", "origin")
+		remoteCmd = exec.CommandContext(ctx, "git", "-C", absPath, "remote", "get-url", remoteName)
 	} else {
-		remoteCmd = exec.Command("git", "-C", absPath, "remote", "get-url", "origin")
+		remoteCmd = exec.CommandContext(ctx, "git", "-C", absPath, "remote", "get-url", remoteName)
 	}
 	remoteOutput, err := remoteCmd.Output()
 	if err != nil {
-		fmt.Println("Failed to get remote URL:", err)
+		fmt.Fprintln(os.Stderr, "Failed to get remote URL:", err)
 		return ""
 	}
 	remote := strings.TrimSpace(string(remoteOutput))
 	return remote
 }
 
-func GetCurrentBranch(absPath string) string {
-	branchCmd := exec.Command("git", "-C", absPath, "rev-parse", "--abbrev-ref", "HEAD")
+func GetCurrentBranch(ctx context.Context, absPath string) string {
+	branchCmd := exec.CommandContext(ctx, "git", "-C", absPath, "rev-parse", "--abbrev-ref", "HEAD")
 	branchOutput, err := branchCmd.Output()
 	var branch = "master" // Default to 'master' if the current branch cannot be determined
 	if err != nil {
-		fmt.Printf("Error getting current branch: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error getting current branch: %v\n", err)
 	} else {
 		branch = strings.TrimSpace(string(branchOutput))
 	}
 	return branch
 }
+
+// GetBranches lists the branches git knows for the repository: local ones
+// and those of the named remote, without duplicates
+func GetBranches(ctx context.Context, absPath string, remoteName string) ([]string, error) {
+	refsCmd := exec.CommandContext(ctx, "git", "-C", absPath, "for-each-ref", "--format=%(refname)", "refs/heads", "refs/remotes/"+remoteName)
+	output, err := refsCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %v", err)
+	}
+
+	var branches []string
+	seen := map[string]bool{}
+	for _, ref := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		branch := strings.TrimPrefix(ref, "refs/heads/")
+		branch = strings.TrimPrefix(branch, "refs/remotes/"+remoteName+"/")
+		// Skip the remote's symbolic HEAD
+		if branch == "" || branch == "HEAD" || seen[branch] {
+			continue
+		}
+		seen[branch] = true
+		branches = append(branches, branch)
+	}
+	return branches, nil
+}
+
+// Shared so consecutive prompts don't lose buffered input
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// SetStdin makes prompts read their answers from r instead of stdin
+func SetStdin(r io.Reader) {
+	stdinReader = bufio.NewReader(r)
+}
+
+// ReadSecret prompts for a value and reads one line from stdin. Input is not
+// echoed when stdin is a terminal on systems with stty. Echo is turned back
+// on if ctx is cancelled while waiting for input.
+func ReadSecret(ctx context.Context, prompt string) (string, error) {
+	fmt.Print(prompt)
+
+	echoOff := false
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 && runtime.GOOS != "windows" {
+		sttyCmd := exec.Command("stty", "-echo")
+		sttyCmd.Stdin = os.Stdin
+		echoOff = sttyCmd.Run() == nil
+	}
+
+	line, err := readLine(ctx)
+
+	if echoOff {
+		sttyCmd := exec.Command("stty", "echo")
+		sttyCmd.Stdin = os.Stdin
+		sttyCmd.Run()
+		fmt.Println()
+	}
+	return line, err
+}
+
+// Confirm asks a yes/no question on stdin and reports whether the answer was
+// yes. No answer, including the end of input, counts as no.
+func Confirm(ctx context.Context, prompt string) (bool, error) {
+	fmt.Print(prompt + " [y/N]: ")
+	line, err := readLine(ctx)
+	if errors.Is(err, io.EOF) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	answer := strings.ToLower(line)
+	return answer == "y" || answer == "yes", nil
+}
+
+// Helper to read one trimmed line from stdin, giving up when ctx is
+// cancelled. A last line without a newline is accepted.
+func readLine(ctx context.Context) (string, error) {
+	type input struct {
+		line string
+		err  error
+	}
+	inputs := make(chan input, 1)
+	go func() {
+		line, err := stdinReader.ReadString('\n')
+		inputs <- input{line, err}
+	}()
+
+	var in input
+	select {
+	case in = <-inputs:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if in.err != nil && !(in.err == io.EOF && in.line != "") {
+		return "", fmt.Errorf("failed to read input: %w", in.err)
+	}
+	return strings.TrimSpace(in.line), nil
+}
+
+// GitCredentialFill asks git's credential helpers for the password stored for
+// an https host, without ever prompting the user
+func GitCredentialFill(ctx context.Context, host string) (string, error) {
+	credentialCmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	credentialCmd.Stdin = strings.NewReader("protocol=https\nhost=" + host + "\n\n")
+	credentialCmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+
+	output, err := credentialCmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git credential fill failed for %s: %v", host, err)
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "password=") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "password=")), nil
+		}
+	}
+	return "", fmt.Errorf("no credential stored for %s", host)
+}